@@ -0,0 +1,55 @@
+//go:build go1.18
+
+package cur
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+// addSeeds adds small cursors to the seed corpus, as large inputs slow fuzzing down.
+func addSeeds(f *testing.F) {
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 16)
+	}
+	var buf bytes.Buffer
+	if err := EncodeCUR(&buf, &CUR{
+		Cursor:  []image.Image{m, image.NewGray(image.Rect(0, 0, 2, 2))},
+		Hotspot: []Hotspot{{X: 1, Y: 2}, {}},
+	}); err != nil {
+		f.Fatalf("EncodeCUR() = %v; want nil", err)
+	}
+	f.Add(buf.Bytes())
+}
+
+// tooLarge reports whether b declares images too large to decode while fuzzing.
+func tooLarge(b []byte) bool {
+	_, err := NewDecoder(bytes.NewReader(b), &DecodeOptions{MaxEntries: 32, MaxWidth: 1024, MaxHeight: 1024})
+	return errors.Is(err, ErrLimit)
+}
+
+func FuzzDecodeAll(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if tooLarge(b) {
+			return
+		}
+		c, err := DecodeAll(bytes.NewReader(b))
+		if err == nil && (len(c.Cursor) == 0 || len(c.Cursor) != len(c.Hotspot)) {
+			t.Fatalf("DecodeAll() = %d cursors and %d hotspots, nil", len(c.Cursor), len(c.Hotspot))
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if tooLarge(b) {
+			return
+		}
+		DecodeConfig(bytes.NewReader(b))
+	})
+}
@@ -2,22 +2,77 @@
 package cur
 
 import (
+	"errors"
 	"image"
 	"io"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/sergeymakinen/go-ico/internal/icondir"
 )
 
+// Errors matched by errors.Is against the errors of the package. They are shared
+// by packages ico and cur, and match the errors of the underlying PNG and BMP
+// decoders wrapped by EntryError as well.
+var (
+	ErrFormat      = icondir.ErrFormat
+	ErrUnsupported = icondir.ErrUnsupported
+	ErrLimit       = icondir.ErrLimit
+)
+
+// ErrIndex is returned by the Decoder methods given a cursor index out of range,
+// which is a misuse rather than an invalid input.
+var ErrIndex = errors.New("cur: cursor index out of range")
+
 // FormatError reports that the input is not a valid CUR.
 type FormatError string
 
 func (e FormatError) Error() string { return "cur: invalid format: " + string(e) }
 
+// Is reports whether target is ErrFormat.
+func (e FormatError) Is(target error) bool { return target == ErrFormat }
+
 // UnsupportedError reports that the input uses a valid but unimplemented CUR feature.
 type UnsupportedError string
 
 func (e UnsupportedError) Error() string { return "cur: unsupported feature: " + string(e) }
 
+// Is reports whether target is ErrUnsupported.
+func (e UnsupportedError) Is(target error) bool { return target == ErrUnsupported }
+
+// LimitError reports that the input exceeds one of the DecodeOptions limits.
+type LimitError string
+
+func (e LimitError) Error() string { return "cur: limit exceeded: " + string(e) }
+
+// Is reports whether target is ErrLimit.
+func (e LimitError) Is(target error) bool { return target == ErrLimit }
+
+// EntryError reports an error related to a single cursor.
+type EntryError struct {
+	// Entry is the index of the cursor in the directory.
+	Entry int
+
+	// Offset is the position of the directory entry or the cursor data
+	// in the CUR file the error is related to.
+	Offset int64
+
+	// Err is the underlying error, such as FormatError
+	// or an error of the image/png package.
+	Err error
+}
+
+func (e *EntryError) Error() string {
+	return "cur: cursor " + strconv.Itoa(e.Entry) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + strings.TrimPrefix(e.Err.Error(), "cur: ")
+}
+
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Is reports whether target is the sentinel error matching
+// the underlying PNG or BMP decoder error.
+func (e *EntryError) Is(target error) bool { return target != nil && target == icondir.Kind(e.Err) }
+
 // Hotspot represents the coordinates of the cursor hotspot.
 type Hotspot struct {
 	X, Y int
@@ -27,25 +82,232 @@ type Hotspot struct {
 type CUR struct {
 	Cursor  []image.Image
 	Hotspot []Hotspot
+
+	// Mask, if not nil, holds the explicit AND masks of the cursors
+	// used by EncodeCUR. See Decoder.DecodeMask for the description.
+	// A nil mask, including a nil *image.Paletted returned by Decoder.DecodeMask,
+	// is made of the fully transparent pixels of the cursor.
+	Mask []image.Image
 }
 
-// DecodeAll reads a CUR image from r and returns the stored cursors.
-func DecodeAll(r io.Reader) (*CUR, error) {
-	d := icondir.NewDecoder(r, false)
+// Entry describes a cursor stored in a CUR file.
+type Entry struct {
+	Width, Height int
+
+	// Colors is the number of colors in the cursor palette or 0 if there is no palette.
+	Colors int
+
+	// BPP is the number of bits per pixel.
+	BPP int
+
+	Hotspot Hotspot
+
+	// Offset and Size are the location and size of the cursor data in the CUR file.
+	Offset, Size int64
+
+	// PNG reports whether the cursor is stored as a PNG image rather than a BMP one.
+	PNG bool
+}
+
+func newEntry(e *icondir.Entry) Entry {
+	return Entry{
+		Width:  e.Width,
+		Height: e.Height,
+		Colors: e.Colors,
+		BPP:    e.BPP,
+		Hotspot: Hotspot{
+			X: e.XHotspot,
+			Y: e.YHotspot,
+		},
+		Offset: e.Offset,
+		Size:   e.Size,
+		PNG:    e.PNG(),
+	}
+}
+
+// DecodeOptions are the decoding parameters.
+type DecodeOptions struct {
+	// Keep, if not nil, selects the cursors to decode. It's called for every
+	// directory entry before any cursor data is read, with only the directory
+	// fields of e set: Width, Height, Colors, Hotspot, Offset and Size.
+	// The directory values may differ from the actual ones.
+	// Rejected cursors are left out of the Decoder and, if r doesn't implement
+	// io.Seeker, skipped without being read into memory.
+	Keep func(e Entry) bool
+
+	// MaxEntries, if positive, limits the number of directory entries.
+	MaxEntries int
+
+	// MaxEntrySize, if positive, limits the data size of a single selected cursor in bytes.
+	MaxEntrySize int64
+
+	// MaxTotalSize, if positive, limits the total data size of the selected cursors in bytes.
+	MaxTotalSize int64
+
+	// MaxWidth and MaxHeight, if positive, limit the dimensions of the selected cursors
+	// as declared in their BMP or PNG headers.
+	MaxWidth, MaxHeight int
+
+	// MaskFirst makes the AND mask alone define the transparency of 32 bit-per-pixel
+	// BMP cursors and their alpha channel ignored. By default, the alpha channel is used
+	// unless it's zero for every pixel, as left by many old editors relying on the mask.
+	MaskFirst bool
+}
+
+func (o *DecodeOptions) apply(d *icondir.Decoder) *icondir.Decoder {
+	if o == nil {
+		return d
+	}
+	if o.Keep != nil {
+		d.Keep = func(e *icondir.Entry) bool {
+			e2 := newEntry(e)
+			e2.PNG = false
+			return o.Keep(e2)
+		}
+	}
+	d.Limits = icondir.Limits{
+		Entries:   o.MaxEntries,
+		EntrySize: o.MaxEntrySize,
+		TotalSize: o.MaxTotalSize,
+		Width:     o.MaxWidth,
+		Height:    o.MaxHeight,
+	}
+	d.MaskFirst = o.MaskFirst
+	return d
+}
+
+// Decoder reads the CUR directory once and decodes the stored cursors on demand.
+type Decoder struct {
+	d       *icondir.Decoder
+	entries []Entry
+}
+
+// NewDecoder reads the CUR directory from r and returns a Decoder for the stored cursors.
+// If r doesn't implement io.Seeker, the data of all cursors selected by o.Keep
+// is read into memory. If o is nil, default parameters are used.
+// The returned Decoder is not safe for concurrent use.
+func NewDecoder(r io.Reader, o *DecodeOptions) (*Decoder, error) {
+	return newDecoder(o.apply(icondir.NewDecoder(r, false)))
+}
+
+// NewDecoderAt reads the CUR directory from r, which holds size bytes, and returns
+// a Decoder for the stored cursors. Every cursor is read through its own io.SectionReader,
+// so the returned Decoder is safe for concurrent use by multiple goroutines.
+// If o is nil, default parameters are used.
+func NewDecoderAt(r io.ReaderAt, size int64, o *DecodeOptions) (*Decoder, error) {
+	return newDecoder(o.apply(icondir.NewDecoderAt(r, size, false)))
+}
+
+func newDecoder(d *icondir.Decoder) (*Decoder, error) {
 	if err := d.DecodeDir(); err != nil {
 		return nil, convertErr(err)
 	}
-	entries, mm, err := d.DecodeAll()
+	var entries []Entry
+	for _, e := range d.Entries() {
+		entries = append(entries, newEntry(e))
+	}
+	return &Decoder{
+		d:       d,
+		entries: entries,
+	}, nil
+}
+
+// Entries returns the descriptions of the stored cursors in the directory order.
+func (d *Decoder) Entries() []Entry {
+	return append([]Entry{}, d.entries...)
+}
+
+// Best returns the index of the largest stored cursor.
+func (d *Decoder) Best() int {
+	e, _ := d.d.Best()
+	return d.index(e)
+}
+
+// Match returns the index of the stored cursor Windows would choose to display
+// at width x height pixels on a bpp bits-per-pixel display, the way the
+// LookupIconIdFromDirectoryEx function does.
+// The size is multiplied by scale, the DPI scale factor of the display
+// (for example, 1.5 for 150%), so 16x16 at 1.25 matches 20x20.
+// A non-positive scale is treated as 1.
+func (d *Decoder) Match(width, height, bpp int, scale float64) int {
+	if scale <= 0 {
+		scale = 1
+	}
+	return d.index(d.d.Match(int(math.Round(float64(width)*scale)), int(math.Round(float64(height)*scale)), bpp))
+}
+
+// Decode decodes the i-th stored cursor.
+func (d *Decoder) Decode(i int) (image.Image, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	m, err := d.d.Decode(e)
 	if err != nil {
 		return nil, convertErr(err)
 	}
+	return m, nil
+}
+
+// DecodeMask decodes the i-th stored cursor into the color (XOR) image and
+// the 1-bit AND mask, which Decode combines into a single image.
+// The mask pixels are white where the screen is made transparent
+// or, under white color pixels, inverted, and black elsewhere.
+// The mask is nil for cursors stored as PNG images.
+func (d *Decoder) DecodeMask(i int) (image.Image, *image.Paletted, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, mask, err := d.d.DecodeMask(e)
+	if err != nil {
+		return nil, nil, convertErr(err)
+	}
+	return m, mask, nil
+}
+
+// DecodeConfig returns the color model and dimensions of the i-th stored cursor
+// without decoding the entire cursor.
+func (d *Decoder) DecodeConfig(i int) (image.Config, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return image.Config{}, err
+	}
+	config, err := d.d.DecodeConfig(e)
+	if err != nil {
+		return image.Config{}, convertErr(err)
+	}
+	return config, nil
+}
+
+// ReadRaw returns the BMP or PNG data of the i-th stored cursor exactly as it is
+// stored in the CUR file. It can be written back unchanged with EncodeRaw.
+func (d *Decoder) ReadRaw(i int) ([]byte, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.d.ReadRaw(e)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return b, nil
+}
+
+// DecodeAll reads a CUR image from r and returns the stored cursors.
+func DecodeAll(r io.Reader) (*CUR, error) {
+	d, err := NewDecoder(r, nil)
+	if err != nil {
+		return nil, err
+	}
 	cur := &CUR{}
-	for i := 0; i < len(entries); i++ {
-		cur.Cursor = append(cur.Cursor, mm[i])
-		cur.Hotspot = append(cur.Hotspot, Hotspot{
-			X: entries[i].XHotspot,
-			Y: entries[i].YHotspot,
-		})
+	for i, e := range d.entries {
+		m, err := d.Decode(i)
+		if err != nil {
+			return nil, err
+		}
+		cur.Cursor = append(cur.Cursor, m)
+		cur.Hotspot = append(cur.Hotspot, e.Hotspot)
 	}
 	return cur, nil
 }
@@ -53,45 +315,65 @@ func DecodeAll(r io.Reader) (*CUR, error) {
 // Decode reads a CUR image from r and returns the largest stored cursor
 // as an image.Image.
 func Decode(r io.Reader) (image.Image, error) {
-	d := icondir.NewDecoder(r, false)
-	if err := d.DecodeDir(); err != nil {
-		return nil, convertErr(err)
-	}
-	e, err := d.Best()
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return nil, convertErr(err)
+		return nil, err
 	}
-	m, err := d.Decode(e)
-	if err != nil {
-		return nil, convertErr(err)
-	}
-	return m, nil
+	return d.Decode(d.Best())
 }
 
 // DecodeConfig returns the color model and dimensions of the largest cursor
 // stored in a CUR image without decoding the entire cursor.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	d := icondir.NewDecoder(r, false)
-	if err := d.DecodeDir(); err != nil {
-		return image.Config{}, convertErr(err)
-	}
-	e, err := d.Best()
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return image.Config{}, convertErr(err)
+		return image.Config{}, err
 	}
-	config, err := d.DecodeConfig(e)
+	return d.DecodeConfig(d.Best())
+}
+
+// DecodeSize reads a CUR image from r and returns the stored cursor
+// that fits the size and color depth best as an image.Image.
+// See Decoder.Match for the description of the arguments.
+func DecodeSize(r io.Reader, width, height, bpp int, scale float64) (image.Image, error) {
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return image.Config{}, convertErr(err)
+		return nil, err
 	}
-	return config, nil
+	return d.Decode(d.Match(width, height, bpp, scale))
+}
+
+func (d *Decoder) entry(i int) (*icondir.Entry, error) {
+	entries := d.d.Entries()
+	if i < 0 || i >= len(entries) {
+		return nil, ErrIndex
+	}
+	return entries[i], nil
+}
+
+func (d *Decoder) index(e *icondir.Entry) int {
+	for i, e2 := range d.d.Entries() {
+		if e2 == e {
+			return i
+		}
+	}
+	return 0
 }
 
 func convertErr(err error) error {
 	switch err := err.(type) {
+	case *icondir.EntryError:
+		return &EntryError{
+			Entry:  err.Entry,
+			Offset: err.Offset,
+			Err:    convertErr(err.Err),
+		}
 	case icondir.FormatError:
 		return FormatError(err.Error())
 	case icondir.UnsupportedError:
 		return UnsupportedError(err.Error())
+	case icondir.LimitError:
+		return LimitError(err.Error())
 	default:
 		return err
 	}
@@ -2,6 +2,7 @@ package cur
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/sergeymakinen/go-ico/internal/testutil"
@@ -54,3 +55,95 @@ func TestDecodeConfigShouldFail(t *testing.T) {
 		t.Fatalf("DecodeConfig() = _, %v; want %s", err, expected)
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testutil.Cursor.MustRead()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	entries := d.Entries()
+	if actual, expected := len(entries), len(testutil.Cursor.Entries); actual != expected {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+	}
+	for i, e := range testutil.Cursor.Entries {
+		if expected := (Hotspot{X: e.XHotspot, Y: e.YHotspot}); entries[i].Hotspot != expected {
+			t.Errorf("Decoder.Entries()[%d].Hotspot = %v; want %v", i, entries[i].Hotspot, expected)
+		}
+		if entries[i].Width != e.Width || entries[i].Height != e.Height || entries[i].BPP != e.BPP {
+			t.Errorf("Decoder.Entries()[%d] = %dx%d-%d; want %dx%d-%d", i, entries[i].Width, entries[i].Height, entries[i].BPP, e.Width, e.Height, e.BPP)
+		}
+	}
+	m, err := d.Decode(2)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, testutil.Cursor.Entries[2].MustDecode(), m)
+}
+
+func TestDecoderKeep(t *testing.T) {
+	d, err := NewDecoder(struct{ io.Reader }{bytes.NewReader(testutil.Cursor.MustRead())}, &DecodeOptions{
+		Keep: func(e Entry) bool { return e.Hotspot == Hotspot{X: 5, Y: 5} },
+	})
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	entries := d.Entries()
+	if actual, expected := len(entries), 1; actual != expected {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+	}
+	m, err := d.Decode(0)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, testutil.Cursor.Entries[2].MustDecode(), m)
+}
+
+func TestDecoderLimits(t *testing.T) {
+	_, err := NewDecoder(bytes.NewReader(testutil.Cursor.MustRead()), &DecodeOptions{MaxWidth: 64})
+	if expected := "cur: cursor 0 at offset 70: limit exceeded: image too large: 128x128"; err == nil || err.Error() != expected {
+		t.Fatalf("NewDecoder() = _, %v; want %s", err, expected)
+	}
+}
+
+func TestDecoderInvalidIndex(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testutil.Cursor.MustRead()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	n := len(d.Entries())
+	if _, err := d.Decode(n); err != ErrIndex {
+		t.Errorf("Decoder.Decode() = _, %v; want %v", err, ErrIndex)
+	}
+	if _, _, err := d.DecodeMask(-1); err != ErrIndex {
+		t.Errorf("Decoder.DecodeMask() = _, _, %v; want %v", err, ErrIndex)
+	}
+	if _, err := d.DecodeConfig(n); err != ErrIndex {
+		t.Errorf("Decoder.DecodeConfig() = _, %v; want %v", err, ErrIndex)
+	}
+	if _, err := d.ReadRaw(n); err != ErrIndex {
+		t.Errorf("Decoder.ReadRaw() = _, %v; want %v", err, ErrIndex)
+	}
+}
+
+func TestDecodeSize(t *testing.T) {
+	m, err := DecodeSize(bytes.NewReader(testutil.Cursor.MustRead()), 32, 32, 32, 1.5)
+	if err != nil {
+		t.Fatalf("DecodeSize() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, testutil.Cursor.Entries[2].MustDecode(), m)
+}
+
+func TestNewDecoderAt(t *testing.T) {
+	b := testutil.Cursor.MustRead()
+	d, err := NewDecoderAt(bytes.NewReader(b), int64(len(b)), nil)
+	if err != nil {
+		t.Fatalf("NewDecoderAt() = _, %v; want nil", err)
+	}
+	for i, e := range testutil.Cursor.Entries {
+		m, err := d.Decode(i)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, e.MustDecode(), m)
+	}
+}
@@ -0,0 +1,37 @@
+package cur
+
+import (
+	"io"
+
+	"github.com/sergeymakinen/go-ico/internal/icondir"
+)
+
+// Recover reads a damaged CUR image from r and decodes as many cursors as possible.
+// It tolerates a wrong cursor count and data sizes running past the end of the file,
+// looks for the cursor data around offsets that are a few bytes off and scans
+// the file for BMP and PNG cursors not listed in the directory. The cursors found
+// in the directory come first, followed by the unlisted ones with the hotspot
+// at the top-left corner.
+// The problems found are returned as issues.
+// The MaxWidth and MaxHeight limits of o are honored by skipping larger cursors,
+// the other fields of o are ignored. If o is nil, no limits are applied.
+// It returns an error if r can't be read or no cursors are recovered.
+func Recover(r io.Reader, o *DecodeOptions) (*CUR, []Issue, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var limits icondir.Limits
+	if o != nil {
+		limits.Width, limits.Height = o.MaxWidth, o.MaxHeight
+	}
+	entries, mm, issues := icondir.Recover(b, false, limits)
+	if len(mm) == 0 {
+		return nil, newIssues(issues), FormatError("no cursors recovered")
+	}
+	c := &CUR{Cursor: mm}
+	for _, e := range entries {
+		c.Hotspot = append(c.Hotspot, Hotspot{X: e.XHotspot, Y: e.YHotspot})
+	}
+	return c, newIssues(issues), nil
+}
@@ -0,0 +1,34 @@
+package cur
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestRecover(t *testing.T) {
+	b := testutil.Cursor.MustRead()
+	c, issues, err := Recover(bytes.NewReader(b[:len(b)-10]), nil)
+	if err != nil {
+		t.Fatalf("Recover() = _, _, %v; want nil", err)
+	}
+	var actual []string
+	for _, i := range issues {
+		actual = append(actual, fmt.Sprintf("%d@%d: %s", i.Entry, i.Offset, i))
+	}
+	expected := []string{
+		"3@94270: warning: data of 4264 bytes runs past the end of the file",
+		"3@94270: warning: missing AND mask",
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Recover() = _, %q, nil; want %q", actual, expected)
+	}
+	testutil.CompareIconDir(t, testutil.Cursor, nil, c.Cursor)
+	for i, e := range testutil.Cursor.Entries {
+		if expected := (Hotspot{X: e.XHotspot, Y: e.YHotspot}); c.Hotspot[i] != expected {
+			t.Errorf("CUR.Hotspot[%d] = %v; want %v", i, c.Hotspot[i], expected)
+		}
+	}
+}
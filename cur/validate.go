@@ -0,0 +1,73 @@
+package cur
+
+import (
+	"io"
+
+	"github.com/sergeymakinen/go-ico/internal/icondir"
+)
+
+// Severity is the severity of an Issue.
+type Severity int
+
+const (
+	// SeverityWarning means the file violates the CUR format, but can still be decoded.
+	SeverityWarning Severity = iota + 1
+
+	// SeverityError means the file or some of the cursors can't be decoded.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Issue describes a problem found in a CUR file.
+type Issue struct {
+	Severity Severity
+
+	// Entry is the index of the cursor in the directory or -1
+	// if the problem isn't related to a single cursor.
+	Entry int
+
+	// Offset is the position in the CUR file the problem is found at.
+	Offset int64
+
+	Message string
+}
+
+func (i Issue) String() string {
+	return i.Severity.String() + ": " + i.Message
+}
+
+// Validate reads a CUR image from r and reports its problems, such as
+// the directory disagreeing with the stored cursors, overlapping or
+// out-of-bounds cursor data, duplicate cursors or hotspots outside the cursors.
+// Unlike Decode, it doesn't stop at the first problem.
+// It returns nil if no problems are found.
+func Validate(r io.Reader) []Issue {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return []Issue{{Severity: SeverityError, Entry: -1, Offset: int64(len(b)), Message: err.Error()}}
+	}
+	return newIssues(icondir.Validate(b, false))
+}
+
+func newIssues(ii []icondir.Issue) []Issue {
+	var issues []Issue
+	for _, i := range ii {
+		issues = append(issues, Issue{
+			Severity: Severity(i.Severity),
+			Entry:    i.Entry,
+			Offset:   i.Offset,
+			Message:  i.Message,
+		})
+	}
+	return issues
+}
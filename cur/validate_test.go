@@ -0,0 +1,26 @@
+package cur
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestValidate(t *testing.T) {
+	b := testutil.Cursor.MustRead()
+	if issues := Validate(bytes.NewReader(b)); issues != nil {
+		t.Errorf("Validate() = %v; want nil", issues)
+	}
+	binary.LittleEndian.PutUint16(b[54+4:], 40)
+	var actual []string
+	for _, i := range Validate(bytes.NewReader(b)) {
+		actual = append(actual, fmt.Sprintf("%d@%d: %s", i.Entry, i.Offset, i))
+	}
+	expected := []string{"3@58: error: hotspot 40x3 is outside the 32x32 image"}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Validate() = %q; want %q", actual, expected)
+	}
+}
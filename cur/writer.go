@@ -8,6 +8,7 @@ import (
 )
 
 // EncodeAll writes the cursors in mm to w in CUR format.
+// The hotspot of every cursor is set to the top-left corner.
 func EncodeAll(w io.Writer, mm []image.Image) error {
 	e := icondir.NewEncoder(w, false)
 	for _, m := range mm {
@@ -18,7 +19,47 @@ func EncodeAll(w io.Writer, mm []image.Image) error {
 	return e.Encode()
 }
 
+// EncodeCUR writes the cursors in c to w in CUR format
+// along with their hotspots and masks. Cursors with masks
+// are always stored as BMP images.
+func EncodeCUR(w io.Writer, c *CUR) error {
+	if len(c.Hotspot) != len(c.Cursor) {
+		return FormatError("mismatched hotspot count")
+	}
+	if c.Mask != nil && len(c.Mask) != len(c.Cursor) {
+		return FormatError("mismatched mask count")
+	}
+	e := icondir.NewEncoder(w, false)
+	for i, m := range c.Cursor {
+		var o icondir.Options
+		if c.Mask != nil {
+			o.Mask = c.Mask[i]
+		}
+		if err := e.AddOptions(m, c.Hotspot[i].X, c.Hotspot[i].Y, o); err != nil {
+			return convertErr(err)
+		}
+	}
+	return e.Encode()
+}
+
+// EncodeRaw writes the cursors stored as the BMP or PNG data in b, such as
+// returned by Decoder.ReadRaw, to w in CUR format along with their hotspots
+// without reencoding them.
+func EncodeRaw(w io.Writer, b [][]byte, hotspot []Hotspot) error {
+	if len(hotspot) != len(b) {
+		return FormatError("mismatched hotspot count")
+	}
+	e := icondir.NewEncoder(w, false)
+	for i, raw := range b {
+		if err := e.AddRaw(raw, hotspot[i].X, hotspot[i].Y); err != nil {
+			return convertErr(err)
+		}
+	}
+	return e.Encode()
+}
+
 // Encode writes the cursor m to w in CUR format.
+// The hotspot of the cursor is set to the top-left corner.
 func Encode(w io.Writer, m image.Image) error {
 	e := icondir.NewEncoder(w, false)
 	if err := e.Add(m, 0, 0); err != nil {
@@ -3,6 +3,7 @@ package cur
 import (
 	"bytes"
 	"image"
+	"image/color"
 	"testing"
 
 	"github.com/sergeymakinen/go-ico/internal/testutil"
@@ -53,3 +54,154 @@ func TestEncodeShouldFail(t *testing.T) {
 		t.Fatalf("Encode() = %v; want %s", err, expected)
 	}
 }
+
+func TestEncodeCUR(t *testing.T) {
+	cur, err := DecodeAll(bytes.NewReader(testutil.Cursor.MustRead()))
+	if err != nil {
+		t.Fatalf("DecodeAll() = _, %v; want nil", err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeCUR(&buf, cur); err != nil {
+		t.Fatalf("EncodeCUR() = %v; want nil", err)
+	}
+	cur, err = DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll() = _, %v; want nil", err)
+	}
+	testutil.CompareIconDir(t, testutil.Cursor, nil, cur.Cursor)
+	for i, e := range testutil.Cursor.Entries {
+		if expected := (Hotspot{X: e.XHotspot, Y: e.YHotspot}); cur.Hotspot[i] != expected {
+			t.Errorf("CUR.Hotspot[%d] = %v; want %v", i, cur.Hotspot[i], expected)
+		}
+	}
+}
+
+func TestEncodeCURMask(t *testing.T) {
+	// An I-beam: black, inverted and transparent pixels.
+	bw := color.Palette{color.Black, color.White}
+	src := image.NewPaletted(image.Rect(0, 0, 3, 4), bw)
+	mask := image.NewPaletted(src.Rect, bw)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 3; x++ {
+			switch {
+			case x == 1:
+				src.SetColorIndex(x, y, 1)
+				mask.SetColorIndex(x, y, 1)
+			case y == 0 || y == 3:
+			default:
+				mask.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := EncodeCUR(&buf, &CUR{Cursor: []image.Image{src}, Hotspot: []Hotspot{{X: 1, Y: 2}}, Mask: []image.Image{mask}}); err != nil {
+		t.Fatalf("EncodeCUR() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	if e := d.Entries()[0]; e.PNG || e.BPP != 1 {
+		t.Errorf("Entry = PNG %t, %d BPP; want BMP, 1 BPP", e.PNG, e.BPP)
+	}
+	m, mask2, err := d.DecodeMask(0)
+	if err != nil {
+		t.Fatalf("Decoder.DecodeMask() = _, _, %v; want nil", err)
+	}
+	testutil.Compare(t, src, m)
+	testutil.Compare(t, mask, mask2)
+	m, err = d.Decode(0)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	if _, _, _, a := m.At(1, 1).RGBA(); a != 0 {
+		t.Errorf("At(1, 1).A = %d; want 0", a)
+	}
+	if _, _, _, a := m.At(0, 0).RGBA(); a != 0xFFFF {
+		t.Errorf("At(0, 0).A = %d; want 65535", a)
+	}
+	// A nil mask returned by DecodeMask for PNG cursors.
+	buf.Reset()
+	if err := EncodeCUR(&buf, &CUR{Cursor: []image.Image{src}, Hotspot: []Hotspot{{}}, Mask: []image.Image{(*image.Paletted)(nil)}}); err != nil {
+		t.Fatalf("EncodeCUR() = %v; want nil", err)
+	}
+}
+
+func TestEncodeRaw(t *testing.T) {
+	b := testutil.Cursor.MustRead()
+	d, err := NewDecoder(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	var raw [][]byte
+	var hotspot []Hotspot
+	for i, e := range d.Entries() {
+		b, err := d.ReadRaw(i)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		raw = append(raw, b)
+		hotspot = append(hotspot, e.Hotspot)
+	}
+	var buf bytes.Buffer
+	if err := EncodeRaw(&buf, raw, hotspot); err != nil {
+		t.Fatalf("EncodeRaw() = %v; want nil", err)
+	}
+	// The test file has no gaps between entries, so it must be reproduced exactly.
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("EncodeRaw() wrote different data")
+	}
+	err = EncodeRaw(&buf, raw, nil)
+	if expected := "cur: invalid format: mismatched hotspot count"; err == nil || err.Error() != expected {
+		t.Fatalf("EncodeRaw() = %v; want %s", err, expected)
+	}
+}
+
+func TestEncodeCURShouldFail(t *testing.T) {
+	tests := []struct {
+		name     string
+		cur      *CUR
+		expected string
+	}{
+		{
+			name:     "mismatched hotspot count",
+			cur:      &CUR{Cursor: []image.Image{image.NewGray(image.Rect(0, 0, 32, 32))}},
+			expected: "cur: invalid format: mismatched hotspot count",
+		},
+		{
+			name: "hotspot outside image",
+			cur: &CUR{
+				Cursor:  []image.Image{image.NewGray(image.Rect(0, 0, 32, 32))},
+				Hotspot: []Hotspot{{X: 32, Y: 0}},
+			},
+			expected: "cur: invalid format: invalid hotspot: 32x0",
+		},
+		{
+			name: "mismatched mask count",
+			cur: &CUR{
+				Cursor:  []image.Image{image.NewGray(image.Rect(0, 0, 32, 32))},
+				Hotspot: []Hotspot{{X: 0, Y: 0}},
+				Mask:    []image.Image{},
+			},
+			expected: "cur: invalid format: mismatched mask count",
+		},
+		{
+			name: "mismatched mask size",
+			cur: &CUR{
+				Cursor:  []image.Image{image.NewGray(image.Rect(0, 0, 32, 32))},
+				Hotspot: []Hotspot{{X: 0, Y: 0}},
+				Mask:    []image.Image{image.NewGray(image.Rect(0, 0, 32, 16))},
+			},
+			expected: "cur: invalid format: mismatched mask size: 32x16",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := EncodeCUR(&buf, test.cur)
+			if err == nil || err.Error() != test.expected {
+				t.Fatalf("EncodeCUR() = %v; want %s", err, test.expected)
+			}
+		})
+	}
+}
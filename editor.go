@@ -0,0 +1,131 @@
+package ico
+
+import (
+	"image"
+	"io"
+
+	"github.com/sergeymakinen/go-ico/internal/icondir"
+)
+
+// Editor adds, removes and replaces icons of an ICO file.
+// The data of the icons which are not changed is preserved as is.
+//
+// The zero value is an empty Editor.
+type Editor struct {
+	entries []*icondir.Entry
+}
+
+// NewEditor reads the ICO image from r into memory and returns an Editor for it.
+func NewEditor(r io.Reader) (*Editor, error) {
+	d := icondir.NewDecoder(r, true)
+	if err := d.DecodeDir(); err != nil {
+		return nil, convertErr(err)
+	}
+	ed := &Editor{}
+	for _, e := range d.Entries() {
+		b, err := d.ReadRaw(e)
+		if err != nil {
+			return nil, convertErr(err)
+		}
+		e2, err := icondir.NewRawEntry(b, 0, 0)
+		if err != nil {
+			return nil, convertErr(err)
+		}
+		// Keep the original location for reference.
+		e2.Offset = e.Offset
+		ed.entries = append(ed.entries, e2)
+	}
+	return ed, nil
+}
+
+// Entries returns the descriptions of the icons in the directory order.
+// Offset is 0 for the icons added by the Editor.
+func (ed *Editor) Entries() []Entry {
+	var entries []Entry
+	for _, e := range ed.entries {
+		entries = append(entries, newEntry(e))
+	}
+	return entries
+}
+
+// Delete removes the i-th icon.
+func (ed *Editor) Delete(i int) error {
+	if i < 0 || i >= len(ed.entries) {
+		return ErrIndex
+	}
+	ed.entries = append(ed.entries[:i], ed.entries[i+1:]...)
+	return nil
+}
+
+// DeleteFunc removes all the icons for which f returns true.
+func (ed *Editor) DeleteFunc(f func(e Entry) bool) {
+	entries := ed.entries[:0]
+	for _, e := range ed.entries {
+		if !f(newEntry(e)) {
+			entries = append(entries, e)
+		}
+	}
+	ed.entries = entries
+}
+
+// Insert encodes the icon m with the options o, which may be nil,
+// and inserts it at the index i. If i is equal to the number of icons,
+// the icon is appended.
+func (ed *Editor) Insert(i int, m image.Image, o *EncodeOptions) error {
+	if i < 0 || i > len(ed.entries) {
+		return ErrIndex
+	}
+	e, err := icondir.NewEntry(m, 0, 0, o.merge(nil).options())
+	if err != nil {
+		return convertErr(err)
+	}
+	ed.insert(i, e)
+	return nil
+}
+
+// InsertRaw inserts the icon stored as the BMP or PNG data b at the index i
+// without reencoding it. If i is equal to the number of icons,
+// the icon is appended.
+func (ed *Editor) InsertRaw(i int, b []byte) error {
+	if i < 0 || i > len(ed.entries) {
+		return ErrIndex
+	}
+	e, err := icondir.NewRawEntry(b, 0, 0)
+	if err != nil {
+		return convertErr(err)
+	}
+	ed.insert(i, e)
+	return nil
+}
+
+// Replace encodes the icon m with the options o, which may be nil,
+// and replaces the i-th icon with it.
+func (ed *Editor) Replace(i int, m image.Image, o *EncodeOptions) error {
+	if i < 0 || i >= len(ed.entries) {
+		return ErrIndex
+	}
+	e, err := icondir.NewEntry(m, 0, 0, o.merge(nil).options())
+	if err != nil {
+		return convertErr(err)
+	}
+	ed.entries[i] = e
+	return nil
+}
+
+// Save writes the icons to w in ICO format.
+func (ed *Editor) Save(w io.Writer) error {
+	if len(ed.entries) == 0 {
+		return FormatError("no icons")
+	}
+	e := icondir.NewEncoder(w, true)
+	for _, entry := range ed.entries {
+		e.AddEntry(entry)
+	}
+	return convertErr(e.Encode())
+}
+
+func (ed *Editor) insert(i int, e *icondir.Entry) {
+	ed.entries = append(ed.entries, nil)
+	copy(ed.entries[i+1:], ed.entries[i:])
+	ed.entries[i] = e
+}
@@ -0,0 +1,103 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestEditor(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	ed, err := NewEditor(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewEditor() = _, %v; want nil", err)
+	}
+	ed.DeleteFunc(func(e Entry) bool { return e.BPP == 1 })
+	if err := ed.Delete(0); err != nil {
+		t.Fatalf("Editor.Delete() = %v; want nil", err)
+	}
+	src := testutil.Icon.Entries[11].MustDecode()
+	if err := ed.Insert(0, src, &EncodeOptions{Format: AlwaysBMP}); err != nil {
+		t.Fatalf("Editor.Insert() = %v; want nil", err)
+	}
+	original := testutil.Icon.Entries[14]
+	raw := func(i int) []byte {
+		d, err := NewDecoder(bytes.NewReader(b), nil)
+		if err != nil {
+			t.Fatalf("NewDecoder() = _, %v; want nil", err)
+		}
+		raw, err := d.ReadRaw(i)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		return raw
+	}
+	if err := ed.InsertRaw(len(ed.Entries()), raw(14)); err != nil {
+		t.Fatalf("Editor.InsertRaw() = %v; want nil", err)
+	}
+	if err := ed.Replace(1, testutil.Icon.Entries[9].MustDecode(), nil); err != nil {
+		t.Fatalf("Editor.Replace() = %v; want nil", err)
+	}
+	var buf bytes.Buffer
+	if err := ed.Save(&buf); err != nil {
+		t.Fatalf("Editor.Save() = %v; want nil", err)
+	}
+	d, err := NewDecoder(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	// The 256x256 icon, the replaced 32x32 icon, icons #4-#14 and the appended 16x16 icon.
+	expected := []int{11, 9, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 14}
+	entries := d.Entries()
+	if actual := len(entries); actual != len(expected) {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, len(expected))
+	}
+	for i, j := range expected {
+		e := testutil.Icon.Entries[j]
+		if entries[i].Width != e.Width || entries[i].Height != e.Height {
+			t.Errorf("Decoder.Entries()[%d] = %dx%d; want %dx%d", i, entries[i].Width, entries[i].Height, e.Width, e.Height)
+		}
+		if i >= 2 {
+			raw2, err := d.ReadRaw(i)
+			if err != nil {
+				t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+			}
+			if !bytes.Equal(raw2, raw(j)) {
+				t.Errorf("Decoder.ReadRaw(%d) returned different data", i)
+			}
+		}
+	}
+	if entries[0].PNG {
+		t.Errorf("Decoder.Entries()[0].PNG = true; want false")
+	}
+	m, err := d.Decode(len(entries) - 1)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, original.MustDecode(), m)
+}
+
+func TestEditorShouldFail(t *testing.T) {
+	var ed Editor
+	if err := ed.Insert(0, &image.Gray{}, nil); err == nil || err.Error() != "ico: invalid format: invalid image size: 0x0" {
+		t.Fatalf("Editor.Insert() = %v; want ico: invalid format: invalid image size: 0x0", err)
+	}
+	if err := ed.Insert(1, testutil.Icon.Entries[14].MustDecode(), nil); err != ErrIndex {
+		t.Fatalf("Editor.Insert() = %v; want %v", err, ErrIndex)
+	}
+	if err := ed.InsertRaw(-1, nil); err != ErrIndex {
+		t.Fatalf("Editor.InsertRaw() = %v; want %v", err, ErrIndex)
+	}
+	if err := ed.Replace(0, testutil.Icon.Entries[14].MustDecode(), nil); err != ErrIndex {
+		t.Fatalf("Editor.Replace() = %v; want %v", err, ErrIndex)
+	}
+	if err := ed.Delete(0); err != ErrIndex {
+		t.Fatalf("Editor.Delete() = %v; want %v", err, ErrIndex)
+	}
+	var buf bytes.Buffer
+	if err := ed.Save(&buf); err == nil || err.Error() != "ico: invalid format: no icons" {
+		t.Fatalf("Editor.Save() = %v; want ico: invalid format: no icons", err)
+	}
+}
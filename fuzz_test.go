@@ -0,0 +1,91 @@
+//go:build go1.18
+
+package ico
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+// addSeeds adds small icons to the seed corpus, as large inputs slow fuzzing down.
+func addSeeds(f *testing.F) {
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 16)
+	}
+	for _, o := range []*EncodeOptions{
+		{BPP: 1},
+		{BPP: 4},
+		{BPP: 8},
+		{BPP: 24},
+		{BPP: 32, Format: AlwaysBMP},
+		{Format: AlwaysPNG},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, o)
+		if err := e.Add(m, nil); err != nil {
+			f.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+		if err := e.Encode(); err != nil {
+			f.Fatalf("Encoder.Encode() = %v; want nil", err)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+// tooLarge reports whether b declares images too large to decode while fuzzing.
+// The dimensions of BMP images aren't limited as their pixels must fit into
+// the entry data, unlike the ones of PNG images.
+func tooLarge(b []byte) bool {
+	d, err := NewDecoder(bytes.NewReader(b), &DecodeOptions{MaxEntries: 32, MaxEntrySize: 1 << 20})
+	if err != nil {
+		return errors.Is(err, ErrLimit)
+	}
+	for _, e := range d.Entries() {
+		if e.PNG && (e.Width > 1024 || e.Height > 1024) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzDecode(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if tooLarge(b) {
+			return
+		}
+		m, err := Decode(bytes.NewReader(b))
+		if err == nil && m == nil {
+			t.Fatal("Decode() = nil, nil")
+		}
+	})
+}
+
+func FuzzDecodeAll(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if tooLarge(b) {
+			return
+		}
+		for _, r := range []io.Reader{bytes.NewReader(b), struct{ io.Reader }{bytes.NewReader(b)}} {
+			mm, err := DecodeAll(r)
+			if err == nil && len(mm) == 0 {
+				t.Fatal("DecodeAll() = [], nil")
+			}
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if tooLarge(b) {
+			return
+		}
+		DecodeConfig(bytes.NewReader(b))
+	})
+}
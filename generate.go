@@ -0,0 +1,109 @@
+package ico
+
+import (
+	"image"
+	"io"
+	"strconv"
+
+	"github.com/sergeymakinen/go-ico/internal/resample"
+)
+
+// DefaultSizes are the icon sizes generated when no sizes are specified.
+var DefaultSizes = []int{16, 20, 24, 32, 40, 48, 64, 256}
+
+// Filter is a resampling filter used to scale images.
+type Filter int
+
+const (
+	// Lanczos3 is the Lanczos filter with 3 lobes. It is the default filter.
+	Lanczos3 Filter = iota
+
+	// Box is the box filter which averages the covered pixels.
+	Box
+
+	// Bilinear is the bilinear (triangle) filter.
+	Bilinear
+
+	// CatmullRom is the Catmull-Rom cubic filter.
+	CatmullRom
+
+	// Mitchell is the Mitchell-Netravali cubic filter.
+	Mitchell
+)
+
+func (f Filter) filter() resample.Filter {
+	switch f {
+	case Box:
+		return resample.Box
+	case Bilinear:
+		return resample.Bilinear
+	case CatmullRom:
+		return resample.CatmullRom
+	case Mitchell:
+		return resample.Mitchell
+	default:
+		return resample.Lanczos3
+	}
+}
+
+// Generator generates icons of multiple sizes from a single source image.
+type Generator struct {
+	// Sizes are the widths and heights of the square icons to generate.
+	// If empty, DefaultSizes are used.
+	Sizes []int
+
+	// Overrides are hand-drawn images stored instead of the resampled source image
+	// for the icons of the same size. Overrides that don't match any size are ignored.
+	Overrides []image.Image
+
+	// Filter is the filter used to resample the source image.
+	// Images are resampled in premultiplied linear light.
+	Filter Filter
+
+	// Options are the options used to encode the icons, they may be nil.
+	// Sizes larger than 256 require Options.Large to be set.
+	Options *EncodeOptions
+}
+
+// Generate writes to w an ICO image with the icons of g.Sizes produced
+// by resampling src.
+func (g *Generator) Generate(w io.Writer, src image.Image) error {
+	sizes := g.Sizes
+	if len(sizes) == 0 {
+		sizes = DefaultSizes
+	}
+	if src.Bounds().Empty() {
+		d := src.Bounds().Size()
+		return FormatError("invalid image size: " + strconv.Itoa(d.X) + "x" + strconv.Itoa(d.Y))
+	}
+	e := NewEncoder(w, g.Options)
+	for _, size := range sizes {
+		if size < 1 {
+			return FormatError("invalid image size: " + strconv.Itoa(size) + "x" + strconv.Itoa(size))
+		}
+		var m image.Image
+		for _, o := range g.Overrides {
+			if d := o.Bounds().Size(); d.X == size && d.Y == size {
+				m = o
+				break
+			}
+		}
+		if m == nil {
+			m = src
+			if d := m.Bounds().Size(); d.X != size || d.Y != size {
+				m = resample.Resize(src, size, size, g.Filter.filter())
+			}
+		}
+		if err := e.Add(m, nil); err != nil {
+			return err
+		}
+	}
+	return e.Encode()
+}
+
+// Generate writes to w an ICO image with the square icons of the given sizes
+// produced by resampling src. If no sizes are given, DefaultSizes are used.
+func Generate(w io.Writer, src image.Image, sizes ...int) error {
+	g := &Generator{Sizes: sizes}
+	return g.Generate(w, src)
+}
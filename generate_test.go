@@ -0,0 +1,111 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"strconv"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestGenerate(t *testing.T) {
+	src := testutil.Icon.Entries[11].MustDecode()
+	var buf bytes.Buffer
+	if err := Generate(&buf, src); err != nil {
+		t.Fatalf("Generate() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	entries := d.Entries()
+	if actual, expected := len(entries), len(DefaultSizes); actual != expected {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+	}
+	for i, size := range DefaultSizes {
+		if entries[i].Width != size || entries[i].Height != size {
+			t.Errorf("Decoder.Entries()[%d] = %dx%d; want %dx%d", i, entries[i].Width, entries[i].Height, size, size)
+		}
+	}
+	m, err := d.Decode(len(entries) - 1)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, src, m)
+}
+
+func TestGeneratorOverrides(t *testing.T) {
+	override := testutil.Icon.Entries[14].MustDecode()
+	g := &Generator{
+		Sizes:     []int{32, 16},
+		Overrides: []image.Image{override},
+	}
+	var buf bytes.Buffer
+	if err := g.Generate(&buf, testutil.Icon.Entries[11].MustDecode()); err != nil {
+		t.Fatalf("Generator.Generate() = %v; want nil", err)
+	}
+	mm, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll() = _, %v; want nil", err)
+	}
+	if actual, expected := len(mm), 2; actual != expected {
+		t.Fatalf("len([]image.Image) = %d; want %d", actual, expected)
+	}
+	if expected := image.Rect(0, 0, 32, 32); !mm[0].Bounds().Eq(expected) {
+		t.Errorf("Bounds() = %s; want %s", mm[0].Bounds(), expected)
+	}
+	testutil.Compare(t, override, mm[1])
+}
+
+func TestGenerateShouldFail(t *testing.T) {
+	var buf bytes.Buffer
+	err := Generate(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), 0)
+	if expected := "ico: invalid format: invalid image size: 0x0"; err == nil || err.Error() != expected {
+		t.Fatalf("Generate() = %v; want %s", err, expected)
+	}
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 0), image.Rect(0, 0, 1, 0)} {
+		err := Generate(&buf, image.NewGray(r))
+		if expected := "ico: invalid format: invalid image size: " + strconv.Itoa(r.Dx()) + "x0"; err == nil || err.Error() != expected {
+			t.Errorf("Generate() = %v; want %s", err, expected)
+		}
+	}
+}
+
+func TestGeneratorFilter(t *testing.T) {
+	for _, f := range []Filter{Lanczos3, Box, Bilinear, CatmullRom, Mitchell} {
+		g := &Generator{
+			Sizes:  []int{24},
+			Filter: f,
+		}
+		var buf bytes.Buffer
+		if err := g.Generate(&buf, testutil.Icon.Entries[12].MustDecode()); err != nil {
+			t.Fatalf("Generator.Generate() = %v; want nil", err)
+		}
+		config, err := DecodeConfig(&buf)
+		if err != nil {
+			t.Fatalf("DecodeConfig() = _, %v; want nil", err)
+		}
+		if expected := 24; config.Width != expected {
+			t.Errorf("image.Config.Width = %d; want %d", config.Width, expected)
+		}
+	}
+}
+
+func TestGeneratorLarge(t *testing.T) {
+	g := &Generator{
+		Sizes:   []int{256, 512},
+		Options: &EncodeOptions{Large: true},
+	}
+	var buf bytes.Buffer
+	if err := g.Generate(&buf, testutil.Icon.Entries[11].MustDecode()); err != nil {
+		t.Fatalf("Generator.Generate() = %v; want nil", err)
+	}
+	config, err := DecodeConfig(&buf)
+	if err != nil {
+		t.Fatalf("DecodeConfig() = _, %v; want nil", err)
+	}
+	if expected := 512; config.Width != expected || config.Height != expected {
+		t.Errorf("image.Config = %dx%d; want %dx%d", config.Width, config.Height, expected, expected)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
 	"io"
+	"math/bits"
 	"sort"
+	"strconv"
 
 	"github.com/sergeymakinen/go-bmp"
 )
@@ -25,20 +28,83 @@ const (
 	fileHeaderLen = 6
 	dirEntryLen   = 16
 
-	bmpFileHeaderLen = 14
+	bmpFileHeaderLen   = 14
+	bmpInfoHeaderLen   = 40
+	bmpV4InfoHeaderLen = 108
+	bmpV5InfoHeaderLen = 124
+)
+
+// BMP compression methods.
+const (
+	biRGB       = 0
+	biRLE8      = 1
+	biRLE4      = 2
+	biBitFields = 3
+)
+
+var (
+	ErrFormat      = errors.New("invalid format")
+	ErrUnsupported = errors.New("unsupported feature")
+	ErrLimit       = errors.New("limit exceeded")
 )
 
 type FormatError string
 
 func (e FormatError) Error() string { return string(e) }
 
+func (e FormatError) Is(target error) bool { return target == ErrFormat }
+
 type UnsupportedError string
 
 func (e UnsupportedError) Error() string { return string(e) }
 
+func (e UnsupportedError) Is(target error) bool { return target == ErrUnsupported }
+
+type LimitError string
+
+func (e LimitError) Error() string { return string(e) }
+
+func (e LimitError) Is(target error) bool { return target == ErrLimit }
+
+// EntryError reports an error related to the entry with the directory index Entry.
+// Offset is the position of the directory entry or the entry data in the file.
+type EntryError struct {
+	Entry  int
+	Offset int64
+	Err    error
+}
+
+func (e *EntryError) Error() string {
+	return "entry " + strconv.Itoa(e.Entry) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+}
+
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Kind returns the sentinel error matching err, including the errors
+// of the png and bmp packages, or nil.
+func Kind(err error) error {
+	switch err.(type) {
+	case FormatError, png.FormatError, bmp.FormatError:
+		return ErrFormat
+	case UnsupportedError, png.UnsupportedError, bmp.UnsupportedError:
+		return ErrUnsupported
+	case LimitError:
+		return ErrLimit
+	default:
+		return nil
+	}
+}
+
+// Limits restricts the resources the Decoder uses. Zero values mean no limit.
+type Limits struct {
+	Entries              int
+	EntrySize, TotalSize int64
+	Width, Height        int
+}
+
 var maskPalette = color.Palette{
-	color.Transparent,
-	color.Opaque,
+	color.Black,
+	color.White,
 }
 
 type reader struct {
@@ -97,12 +163,49 @@ type Entry struct {
 	Width, Height, Colors, BPP, XHotspot, YHotspot int
 	Offset, Size                                   int64
 
+	index           int
 	data, bmpHeader []byte
-	topDown         bool
+	// headerLen is the length of the BMP data replaced with bmpHeader.
+	// For BI_BITFIELDS images decoded with masks, it covers everything
+	// before the pixels.
+	headerLen int64
+	masks     []uint32
+	// rleLen is the length of the RLE compressed pixels, which are followed
+	// by the AND mask.
+	rleLen  int64
+	topDown bool
+}
+
+func (e *Entry) wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = FormatError("truncated image data")
+	}
+	return &EntryError{Entry: e.index, Offset: e.Offset, Err: err}
+}
+
+func (e *Entry) PNG() bool {
+	return e.bmpHeader == nil
 }
 
 type Decoder struct {
+	// Keep, if not nil, is called for every directory entry before any
+	// image data is read. Entries it rejects are dropped and, for non-seekable
+	// inputs, their data is discarded without being buffered.
+	// Only the directory fields of e are set at this point.
+	Keep func(e *Entry) bool
+
+	Limits Limits
+
+	// MaskFirst makes the AND mask alone define the transparency of
+	// 32 bit-per-pixel BMP images and their alpha channel ignored.
+	// Otherwise, the alpha channel is only ignored if it's zero for every pixel.
+	MaskFirst bool
+
 	r       *reader
+	ra      io.ReaderAt
 	icon    bool
 	entries []*Entry
 }
@@ -114,11 +217,25 @@ func NewDecoder(r io.Reader, icon bool) *Decoder {
 	}
 }
 
+// NewDecoderAt returns a Decoder reading every entry through its own
+// io.SectionReader, so entries can be decoded concurrently after DecodeDir.
+func NewDecoderAt(r io.ReaderAt, size int64, icon bool) *Decoder {
+	return &Decoder{
+		r:    &reader{r: io.NewSectionReader(r, 0, size)},
+		ra:   r,
+		icon: icon,
+	}
+}
+
+func (d *Decoder) Entries() []*Entry {
+	return d.entries
+}
+
 func (d *Decoder) DecodeDir() error {
 	var b [16]byte
 	if _, err := io.ReadFull(d.r, b[:fileHeaderLen]); err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = FormatError("truncated file header")
 		}
 		return err
 	}
@@ -135,24 +252,58 @@ func (d *Decoder) DecodeDir() error {
 		}
 		return FormatError("no cursors")
 	}
+	if d.Limits.Entries > 0 && int(count) > d.Limits.Entries {
+		return LimitError("too many entries: " + strconv.Itoa(int(count)))
+	}
+	var total int64
 	for i := uint16(0); i < count; i++ {
 		if _, err := io.ReadFull(d.r, b[:]); err != nil {
-			if err == io.EOF {
-				err = io.ErrUnexpectedEOF
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = FormatError("truncated directory")
 			}
 			return err
 		}
-		var xHotspot, yHotspot int
-		if !d.icon {
-			xHotspot, yHotspot = int(binary.LittleEndian.Uint16(b[4:])), int(binary.LittleEndian.Uint16(b[6:]))
+		e := &Entry{
+			Width:  int(b[0]),
+			Height: int(b[1]),
+			Colors: int(b[2]),
+			Size:   int64(binary.LittleEndian.Uint32(b[8:])),
+			Offset: int64(binary.LittleEndian.Uint32(b[12:])),
+			index:  int(i),
+		}
+		dirErr := func(err error) error {
+			return &EntryError{Entry: int(i), Offset: fileHeaderLen + int64(i)*dirEntryLen, Err: err}
+		}
+		if e.Width == 0 {
+			e.Width = 256
+		}
+		if e.Height == 0 {
+			e.Height = 256
+		}
+		if d.icon {
+			e.BPP = int(binary.LittleEndian.Uint16(b[6:]))
+		} else {
+			e.XHotspot, e.YHotspot = int(binary.LittleEndian.Uint16(b[4:])), int(binary.LittleEndian.Uint16(b[6:]))
+		}
+		if d.Keep != nil && !d.Keep(e) {
+			continue
 		}
-		size, offset := binary.LittleEndian.Uint32(b[8:]), binary.LittleEndian.Uint32(b[12:])
-		d.entries = append(d.entries, &Entry{
-			XHotspot: xHotspot,
-			YHotspot: yHotspot,
-			Offset:   int64(offset),
-			Size:     int64(size),
-		})
+		if e.Size < bmpInfoHeaderLen {
+			return dirErr(FormatError("invalid entry size: " + strconv.FormatInt(e.Size, 10)))
+		}
+		if d.Limits.EntrySize > 0 && e.Size > d.Limits.EntrySize {
+			return dirErr(LimitError("entry too large: " + strconv.FormatInt(e.Size, 10) + " bytes"))
+		}
+		if total += e.Size; d.Limits.TotalSize > 0 && total > d.Limits.TotalSize {
+			return LimitError("entries too large: " + strconv.FormatInt(total, 10) + " bytes")
+		}
+		d.entries = append(d.entries, e)
+	}
+	if len(d.entries) == 0 {
+		if d.icon {
+			return FormatError("no matching icons")
+		}
+		return FormatError("no matching cursors")
 	}
 	return d.readHeaders()
 }
@@ -160,13 +311,52 @@ func (d *Decoder) DecodeDir() error {
 func (d *Decoder) Best() (*Entry, error) {
 	var best *Entry
 	for _, e := range d.entries {
-		if best == nil || e.Width*e.Height > best.Width*best.Height || e.BPP > best.BPP {
+		if best == nil || e.Width*e.Height > best.Width*best.Height || (e.Width*e.Height == best.Width*best.Height && e.BPP > best.BPP) {
 			best = e
 		}
 	}
 	return best, nil
 }
 
+// Match returns the entry that fits the width x height size and bpp color depth best
+// following the rules of the Windows LookupIconIdFromDirectoryEx function:
+// the closest size is chosen preferring larger entries which can be scaled down
+// over smaller ones, then the deepest color depth not exceeding bpp is chosen
+// or the shallowest one if all of them exceed bpp.
+func (d *Decoder) Match(width, height, bpp int) *Entry {
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	var size *Entry
+	sizeDist, sizeLarger := 0, false
+	for _, e := range d.entries {
+		dist, larger := abs(e.Width-width)+abs(e.Height-height), e.Width >= width && e.Height >= height
+		if size == nil || (larger && !sizeLarger) || (larger == sizeLarger && dist < sizeDist) {
+			size, sizeDist, sizeLarger = e, dist, larger
+		}
+	}
+	var best *Entry
+	for _, e := range d.entries {
+		if e.Width != size.Width || e.Height != size.Height {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = e
+		case best.BPP > bpp:
+			if e.BPP < best.BPP {
+				best = e
+			}
+		case e.BPP <= bpp && e.BPP > best.BPP:
+			best = e
+		}
+	}
+	return best
+}
+
 func (d *Decoder) DecodeAll() ([]*Entry, []image.Image, error) {
 	mm := make([]image.Image, len(d.entries))
 	var err error
@@ -180,20 +370,70 @@ func (d *Decoder) DecodeAll() ([]*Entry, []image.Image, error) {
 }
 
 func (d *Decoder) Decode(e *Entry) (image.Image, error) {
+	m, _, err := d.decode(e, false)
+	return m, e.wrapErr(err)
+}
+
+// DecodeMask decodes the entry e into the color (XOR) image and the AND mask
+// with the pixels set to 1 where the screen is made transparent or inverted.
+// Decode combines them into a single image. The mask is nil for PNG images.
+func (d *Decoder) DecodeMask(e *Entry) (image.Image, *image.Paletted, error) {
+	m, mask, _, err := d.decodeParts(e)
+	if err != nil {
+		return nil, nil, e.wrapErr(err)
+	}
+	if mask != nil {
+		mask.Palette = append(color.Palette{}, maskPalette...)
+	}
+	return m, mask, nil
+}
+
+// decodeParts decodes the color image and the AND mask of the entry e.
+// opaque reports whether no mask bits are set. If the mask can't be read,
+// the color image is returned along with the error.
+func (d *Decoder) decodeParts(e *Entry) (m image.Image, mask *image.Paletted, opaque bool, err error) {
 	r, isPNG, err := d.reader(e)
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 	if isPNG {
-		return png.Decode(r)
+		m, err = png.Decode(r)
+		return m, nil, true, err
+	}
+	switch {
+	case e.masks != nil:
+		m, err = decodeBitFields(r, e)
+	case e.rleLen > 0:
+		// The compressed pixels may end before biSizeImage bytes.
+		lr := &io.LimitedReader{R: r, N: int64(len(e.bmpHeader)) + int64(e.Colors)*4 + e.rleLen}
+		if m, err = bmp.Decode(lr); err == nil {
+			_, err = io.Copy(io.Discard, lr)
+		}
+	default:
+		m, err = bmp.Decode(r)
 	}
-	m, err := bmp.Decode(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
+	}
+	if nrgba, ok := m.(*image.NRGBA); ok && e.BPP == 32 && (d.MaskFirst || alphaZero(nrgba)) {
+		// Many 32 bit-per-pixel images rely on the AND mask only.
+		for i := 3; i < len(nrgba.Pix); i += 4 {
+			nrgba.Pix[i] = 0xFF
+		}
 	}
-	mask, opaque, err := decodeMask(r, e)
+	mask, opaque, err = decodeMask(r, e)
+	return m, mask, opaque, err
+}
+
+// decode decodes the entry e. If lenient is true, a missing or truncated
+// AND mask is ignored, which is reported by noMask.
+func (d *Decoder) decode(e *Entry, lenient bool) (m image.Image, noMask bool, err error) {
+	m, mask, opaque, err := d.decodeParts(e)
 	if err != nil {
-		return nil, err
+		if m == nil || !lenient || (err != io.EOF && err != io.ErrUnexpectedEOF) {
+			return nil, false, err
+		}
+		noMask, opaque = true, true
 	}
 	if !opaque {
 		var transparent color.Color
@@ -205,7 +445,11 @@ func (d *Decoder) Decode(e *Entry) (image.Image, error) {
 				}
 			}
 			if transparent == nil {
-				if len(paletted.Palette) >= 256 {
+				if i, ok := maskedIndex(paletted, mask); ok {
+					// The color is never visible, so the index is kept.
+					transparent = color.Transparent
+					paletted.Palette[i] = transparent
+				} else if len(paletted.Palette) >= 256 {
 					transparent = color.Transparent
 					// The palette is already at its maximum capacity.
 					tmp := image.NewRGBA(m.Bounds())
@@ -219,39 +463,120 @@ func (d *Decoder) Decode(e *Entry) (image.Image, error) {
 		} else {
 			transparent = color.Transparent
 		}
-		dst := m.(draw.Image)
-		for x := 0; x < e.Width; x++ {
-			for y := 0; y < e.Height; y++ {
-				if mask.At(x, y) == color.Opaque {
+		dst, ok := m.(draw.Image)
+		if !ok {
+			tmp := image.NewNRGBA(m.Bounds())
+			draw.Draw(tmp, tmp.Bounds(), m, m.Bounds().Min, draw.Src)
+			dst, m = tmp, tmp
+		}
+		r := mask.Bounds().Intersect(dst.Bounds())
+		for x := r.Min.X; x < r.Max.X; x++ {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				if mask.ColorIndexAt(x, y) == 1 {
 					dst.Set(x, y, transparent)
 				}
 			}
 		}
 	}
-	return m, nil
+	return m, noMask, nil
+}
+
+// maskedIndex returns the palette index used by every masked pixel of m
+// and by no other pixel, if any.
+func maskedIndex(m, mask *image.Paletted) (int, bool) {
+	masked := -1
+	var unmasked [256]bool
+	r := mask.Bounds().Intersect(m.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := int(m.ColorIndexAt(x, y))
+			switch {
+			case mask.ColorIndexAt(x, y) == 0:
+				unmasked[i] = true
+			case masked == -1:
+				masked = i
+			case masked != i:
+				return 0, false
+			}
+		}
+	}
+	// go-bmp doesn't check the pixels against the palette length.
+	if masked == -1 || masked >= len(m.Palette) || unmasked[masked] {
+		return 0, false
+	}
+	return masked, true
+}
+
+// alphaZero reports whether the alpha of every pixel of m is zero.
+func alphaZero(m *image.NRGBA) bool {
+	for i := 3; i < len(m.Pix); i += 4 {
+		if m.Pix[i] != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func (d *Decoder) DecodeConfig(e *Entry) (image.Config, error) {
 	r, isPNG, err := d.reader(e)
 	if err != nil {
-		return image.Config{}, err
-	}
-	if isPNG {
-		return png.DecodeConfig(r)
+		return image.Config{}, e.wrapErr(err)
+	}
+	var config image.Config
+	switch {
+	case isPNG:
+		config, err = png.DecodeConfig(r)
+	case e.masks != nil:
+		config = image.Config{ColorModel: color.NRGBAModel, Width: e.Width, Height: e.Height}
+	default:
+		config, err = bmp.DecodeConfig(r)
+	}
+	return config, e.wrapErr(err)
+}
+
+// ReadRaw returns the BMP or PNG data of the entry e as stored in the file.
+func (d *Decoder) ReadRaw(e *Entry) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case d.ra != nil:
+		r = io.NewSectionReader(d.ra, e.Offset, e.Size)
+	case d.r.CanSeekBackward():
+		if _, err := d.r.Seek(e.Offset, io.SeekStart); err != nil {
+			return nil, e.wrapErr(err)
+		}
+		r = d.r
+	default:
+		return append([]byte{}, e.data...), nil
 	}
-	return bmp.DecodeConfig(r)
+	b, err := readN(r, e.Size)
+	return b, e.wrapErr(err)
 }
 
 func (d *Decoder) readHeaders() error {
 	if !d.r.CanSeekBackward() {
 		return d.readData()
 	}
+	// Unlike readData, nothing reads the entry data before the headers are
+	// decoded, so the sizes the BMP headers are checked against must fit the input.
+	end, err := d.r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
 	for _, e := range d.entries {
-		if _, err := d.r.Seek(e.Offset, io.SeekStart); err != nil {
-			return err
+		if e.Offset+e.Size > end {
+			return e.wrapErr(FormatError("truncated image data"))
 		}
-		if err := decodeHeader(d.r, e); err != nil {
-			return err
+		var r io.Reader
+		if d.ra != nil {
+			r = io.NewSectionReader(d.ra, e.Offset, e.Size)
+		} else {
+			if _, err := d.r.Seek(e.Offset, io.SeekStart); err != nil {
+				return e.wrapErr(err)
+			}
+			r = io.LimitReader(d.r, e.Size)
+		}
+		if err := d.decodeHeader(r, e); err != nil {
+			return e.wrapErr(err)
 		}
 	}
 	return nil
@@ -262,34 +587,65 @@ func (d *Decoder) readData() error {
 	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
 	for _, e := range entries {
 		if _, err := d.r.Seek(e.Offset, io.SeekStart); err != nil {
-			return err
+			return e.wrapErr(err)
 		}
-		e.data = make([]byte, e.Size)
-		if _, err := io.ReadFull(d.r, e.data); err != nil {
-			return err
+		var err error
+		if e.data, err = readN(d.r, e.Size); err != nil {
+			return e.wrapErr(err)
 		}
-		if err := decodeHeader(bytes.NewReader(e.data), e); err != nil {
-			return err
+		if err := d.decodeHeader(bytes.NewReader(e.data), e); err != nil {
+			return e.wrapErr(err)
 		}
 	}
 	return nil
 }
 
 func (d *Decoder) reader(e *Entry) (r io.Reader, isPNG bool, err error) {
-	if d.r.CanSeekBackward() {
-		if _, err = d.r.Seek(e.Offset, io.SeekStart); err != nil {
+	// The BMP info header is replaced with the one stored in e.bmpHeader.
+	off := int64(0)
+	if isPNG = e.PNG(); !isPNG {
+		off = e.headerLen
+	}
+	switch {
+	case d.ra != nil:
+		r = io.NewSectionReader(d.ra, e.Offset+off, e.Size-off)
+	case d.r.CanSeekBackward():
+		if _, err = d.r.Seek(e.Offset+off, io.SeekStart); err != nil {
 			return
 		}
-		r = d.r
-	} else {
-		r = bytes.NewReader(e.data)
+		r = io.LimitReader(d.r, e.Size-off)
+	default:
+		r = bytes.NewReader(e.data[off:])
 	}
-	if isPNG = e.bmpHeader == nil; !isPNG {
+	if !isPNG && e.masks == nil {
 		r = io.MultiReader(bytes.NewReader(e.bmpHeader), r)
 	}
 	return
 }
 
+func (d *Decoder) decodeHeader(r io.Reader, e *Entry) error {
+	if err := decodeHeader(r, e); err != nil {
+		return err
+	}
+	if (d.Limits.Width > 0 && e.Width > d.Limits.Width) || (d.Limits.Height > 0 && e.Height > d.Limits.Height) {
+		return LimitError("image too large: " + strconv.Itoa(e.Width) + "x" + strconv.Itoa(e.Height))
+	}
+	return nil
+}
+
+// readN reads n bytes from r growing the buffer as the data arrives,
+// so a bogus size doesn't cause a large allocation for a short input.
+func readN(r io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 type peekReader interface {
 	io.Reader
 	Peek(int) ([]byte, error)
@@ -370,7 +726,9 @@ func decodePNGHeader(r io.Reader, e *Entry) error {
 		}
 		return err
 	}
+	// Directory values are replaced with the actual ones.
 	e.Width, e.Height = int(binary.BigEndian.Uint32(b[:])), int(binary.BigEndian.Uint32(b[4:]))
+	e.Colors, e.BPP = 0, 0
 	paletted := false
 	switch b[8] {
 	case 1:
@@ -439,21 +797,26 @@ func decodePNGHeader(r io.Reader, e *Entry) error {
 }
 
 func decodeBMPHeader(r io.Reader, e *Entry) error {
-	const infoHeaderLen = 40
-	const biBitFields = 3
-	e.bmpHeader = make([]byte, bmpFileHeaderLen+infoHeaderLen)
-	if _, err := io.ReadFull(r, e.bmpHeader[bmpFileHeaderLen:]); err != nil {
+	var b [bmpV5InfoHeaderLen]byte
+	if _, err := io.ReadFull(r, b[:bmpInfoHeaderLen]); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
 		return err
 	}
-	infoLen := binary.LittleEndian.Uint32(e.bmpHeader[14:])
-	if infoLen < infoHeaderLen {
+	infoLen := binary.LittleEndian.Uint32(b[:])
+	switch infoLen {
+	case bmpInfoHeaderLen, bmpV4InfoHeaderLen, bmpV5InfoHeaderLen:
+	default:
 		return UnsupportedError("BMP image")
 	}
-	e.bmpHeader[0], e.bmpHeader[1] = 'B', 'M'
-	e.Width, e.Height, e.BPP = int(int32(binary.LittleEndian.Uint32(e.bmpHeader[18:]))), int(int32(binary.LittleEndian.Uint32(e.bmpHeader[22:]))), int(binary.LittleEndian.Uint16(e.bmpHeader[28:]))
+	if _, err := io.ReadFull(r, b[bmpInfoHeaderLen:infoLen]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	e.Width, e.Height, e.BPP = int(int32(binary.LittleEndian.Uint32(b[4:]))), int(int32(binary.LittleEndian.Uint32(b[8:]))), int(binary.LittleEndian.Uint16(b[14:]))
 	if e.Height < 0 {
 		e.Height, e.topDown = -e.Height, true
 	}
@@ -461,25 +824,149 @@ func decodeBMPHeader(r io.Reader, e *Entry) error {
 		return UnsupportedError("BMP image")
 	}
 	e.Height /= 2
-	compression := binary.LittleEndian.Uint32(e.bmpHeader[30:])
-	e.Colors = int(binary.LittleEndian.Uint32(e.bmpHeader[46:]))
+	if e.Width < 0 {
+		return FormatError("invalid image size: " + strconv.Itoa(e.Width) + "x" + strconv.Itoa(e.Height))
+	}
+	compression := binary.LittleEndian.Uint32(b[16:])
+	e.Colors = int(binary.LittleEndian.Uint32(b[32:]))
+	if e.Colors > 256 || (e.BPP <= 8 && e.Colors > 1<<e.BPP) {
+		return FormatError("invalid palette size: " + strconv.Itoa(e.Colors))
+	}
 	if e.BPP <= 8 && e.Colors == 0 {
 		e.Colors = 1 << e.BPP
 	}
-	colorMaskLen := uint32(0)
-	if infoLen == infoHeaderLen && (e.BPP == 16 || e.BPP == 32) && compression == biBitFields {
-		colorMaskLen = 4 * 3
+	e.headerLen, e.masks, e.rleLen = int64(infoLen), nil, 0
+	switch {
+	case e.BPP == 16 && compression == biRGB:
+		// The uncompressed 16 bit-per-pixel images are stored as RGB555.
+		e.masks = []uint32{0x7C00, 0x3E0, 0x1F, 0}
+	case (e.BPP == 16 || e.BPP == 32) && compression == biBitFields:
+		// BITMAPINFOHEADER is followed by the red, green and blue masks,
+		// the later versions include them along with the alpha mask.
+		if infoLen == bmpInfoHeaderLen {
+			if _, err := io.ReadFull(r, b[infoLen:infoLen+4*3]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			e.headerLen += 4 * 3
+		}
+		e.masks = make([]uint32, 4)
+		for i := range e.masks {
+			if i < 3 || infoLen > bmpInfoHeaderLen {
+				e.masks[i] = binary.LittleEndian.Uint32(b[bmpInfoHeaderLen+4*i:])
+			}
+			if !validMask(e.masks[i], e.BPP) {
+				return FormatError("invalid bit mask: 0x" + strconv.FormatUint(uint64(e.masks[i]), 16))
+			}
+		}
+	}
+	if e.masks != nil {
+		// The palette, if any, is only an optimization hint for true color images.
+		e.headerLen += int64(e.Colors) * 4
+	}
+	switch {
+	case compression == biRGB || compression == biBitFields:
+		// The uncompressed pixels must fit into the entry data, which fits
+		// into the input, preventing allocating images larger than the input.
+		rowLen := (int64(e.Width)*int64(e.BPP) + 31) / 32 * 4
+		n := e.Size - e.headerLen
+		if e.masks == nil {
+			n -= int64(e.Colors) * 4
+		}
+		if n < 0 || (rowLen > 0 && int64(e.Height) > n/rowLen) {
+			return FormatError("truncated image data")
+		}
+	case (compression == biRLE8 && e.BPP == 8) || (compression == biRLE4 && e.BPP == 4):
+		// The compressed pixels are located with biSizeImage
+		// and the uncompressed AND mask must fit into the rest of the entry data.
+		e.rleLen = int64(binary.LittleEndian.Uint32(b[20:]))
+		maskRowLen := (int64(e.Width) + 31) / 32 * 4
+		n := e.Size - e.headerLen - int64(e.Colors)*4 - e.rleLen
+		if e.rleLen == 0 || n < 0 || (maskRowLen > 0 && int64(e.Height) > n/maskRowLen) {
+			return FormatError("truncated image data")
+		}
 	}
-	binary.LittleEndian.PutUint32(e.bmpHeader[10:], bmpFileHeaderLen+infoLen+uint32(e.Colors)*4+colorMaskLen)
+	// go-bmp is given a BITMAPFILEHEADER followed by BITMAPINFOHEADER
+	// in place of the original info header.
+	e.bmpHeader = make([]byte, bmpFileHeaderLen+bmpInfoHeaderLen)
+	e.bmpHeader[0], e.bmpHeader[1] = 'B', 'M'
+	copy(e.bmpHeader[bmpFileHeaderLen:], b[:bmpInfoHeaderLen])
+	binary.LittleEndian.PutUint32(e.bmpHeader[14:], bmpInfoHeaderLen)
+	binary.LittleEndian.PutUint32(e.bmpHeader[10:], bmpFileHeaderLen+bmpInfoHeaderLen+uint32(e.Colors)*4)
 	// Fix height.
-	binary.LittleEndian.PutUint32(e.bmpHeader[22:], uint32(e.Height))
-	e.Offset, e.Size = e.Offset+infoHeaderLen, e.Size-infoHeaderLen
-	if e.data != nil {
-		e.data = e.data[infoHeaderLen:]
+	height := int32(e.Height)
+	if e.topDown {
+		height = -height
 	}
+	binary.LittleEndian.PutUint32(e.bmpHeader[22:], uint32(height))
 	return nil
 }
 
+// validMask reports whether mask is a contiguous run of bits
+// fitting into bpp bits.
+func validMask(mask uint32, bpp int) bool {
+	if bpp < 32 && mask>>bpp != 0 {
+		return false
+	}
+	mask >>= bits.TrailingZeros32(mask)
+	return mask&(mask+1) == 0
+}
+
+// decodeBitFields reads a 16 or 32 bit-per-pixel BMP image of the entry e
+// from r using the e.masks bit masks. Channels with more or less than 8 bits are scaled
+// to the full range. If there is no alpha mask, the image is opaque.
+func decodeBitFields(r io.Reader, e *Entry) (image.Image, error) {
+	m := image.NewNRGBA(image.Rect(0, 0, e.Width, e.Height))
+	if e.Width == 0 || e.Height == 0 {
+		return m, nil
+	}
+	var shifts, maxes [4]uint32
+	for i, mask := range e.masks {
+		if mask != 0 {
+			shifts[i] = uint32(bits.TrailingZeros32(mask))
+			maxes[i] = mask >> shifts[i]
+		}
+	}
+	// There are bpp bits per pixel, and each row is 4-byte aligned.
+	bytesPerPixel := e.BPP / 8
+	b := make([]byte, (e.Width*bytesPerPixel+3)&^3)
+	y0, y1, yDelta := e.Height-1, -1, -1
+	if e.topDown {
+		y0, y1, yDelta = 0, e.Height, +1
+	}
+	for y := y0; y != y1; y += yDelta {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		p := m.Pix[y*m.Stride : y*m.Stride+e.Width*4]
+		for x := 0; x < e.Width; x++ {
+			var pixel uint32
+			if bytesPerPixel == 2 {
+				pixel = uint32(binary.LittleEndian.Uint16(b[2*x:]))
+			} else {
+				pixel = binary.LittleEndian.Uint32(b[4*x:])
+			}
+			for i, mask := range e.masks {
+				switch {
+				case mask == 0:
+					p[4*x+i] = 0
+				case maxes[i] == 0xFF:
+					p[4*x+i] = uint8((pixel & mask) >> shifts[i])
+				default:
+					v := uint64((pixel & mask) >> shifts[i])
+					p[4*x+i] = uint8((v*0xFF + uint64(maxes[i])/2) / uint64(maxes[i]))
+				}
+			}
+			if e.masks[3] == 0 {
+				p[4*x+3] = 0xFF
+			}
+		}
+	}
+	return m, nil
+}
+
 func decodeMask(r io.Reader, e *Entry) (m *image.Paletted, opaque bool, err error) {
 	paletted := image.NewPaletted(image.Rect(0, 0, e.Width, e.Height), maskPalette)
 	if e.Width == 0 || e.Height == 0 {
@@ -114,7 +114,9 @@ func TestDecoder_DecodeDirShouldFailIcon(t *testing.T) {
 	b[2] = 1
 	expect("no icons")
 	binary.LittleEndian.PutUint16(b[4:], 1)
-	expect("unexpected EOF")
+	expect("entry 0 at offset 6: invalid entry size: 0")
+	binary.LittleEndian.PutUint32(b[14:], 40)
+	expect("entry 0 at offset 0: truncated image data")
 }
 
 func TestDecoder_DecodeDirShouldFailCursor(t *testing.T) {
@@ -129,5 +131,395 @@ func TestDecoder_DecodeDirShouldFailCursor(t *testing.T) {
 	b[2] = 2
 	expect("no cursors")
 	binary.LittleEndian.PutUint16(b[4:], 1)
-	expect("unexpected EOF")
+	expect("entry 0 at offset 6: invalid entry size: 0")
+	binary.LittleEndian.PutUint32(b[14:], 40)
+	expect("entry 0 at offset 0: truncated image data")
+}
+
+func TestDecoder_DecodeDirShouldFailBMP(t *testing.T) {
+	newIcon := func(width, height int32, bpp uint16, colors uint32) []byte {
+		b := make([]byte, 6+16+40+64)
+		b[2], b[4] = 1, 1
+		binary.LittleEndian.PutUint32(b[14:], 40+64)
+		binary.LittleEndian.PutUint32(b[18:], 6+16)
+		h := b[6+16:]
+		binary.LittleEndian.PutUint32(h, 40)
+		binary.LittleEndian.PutUint32(h[4:], uint32(width))
+		binary.LittleEndian.PutUint32(h[8:], uint32(height))
+		binary.LittleEndian.PutUint16(h[12:], 1)
+		binary.LittleEndian.PutUint16(h[14:], bpp)
+		binary.LittleEndian.PutUint32(h[32:], colors)
+		return b
+	}
+	tests := []struct {
+		name     string
+		b        []byte
+		expected string
+	}{
+		{
+			name:     "negative width",
+			b:        newIcon(-4, 8, 32, 0),
+			expected: "entry 0 at offset 22: invalid image size: -4x4",
+		},
+		{
+			name:     "truncated image data",
+			b:        newIcon(1000, 2000, 32, 0),
+			expected: "entry 0 at offset 22: truncated image data",
+		},
+		{
+			name: "size beyond input",
+			b: func() []byte {
+				b := newIcon(20000, 80000, 32, 0)
+				binary.LittleEndian.PutUint32(b[14:], 0xFFFFFFF0)
+				return b
+			}(),
+			expected: "entry 0 at offset 22: truncated image data",
+		},
+		{
+			name:     "large palette",
+			b:        newIcon(1, 2, 8, 0xC0C0C0C0),
+			expected: "entry 0 at offset 22: invalid palette size: 3233857728",
+		},
+		{
+			name:     "palette exceeding bit depth",
+			b:        newIcon(1, 2, 1, 3),
+			expected: "entry 0 at offset 22: invalid palette size: 3",
+		},
+		{
+			name:     "unsupported info header",
+			b:        func() []byte { b := newIcon(1, 2, 32, 0); b[22] = 64; return b }(),
+			expected: "entry 0 at offset 22: BMP image",
+		},
+		{
+			name:     "RLE without image size",
+			b:        newBMPIcon(4, 2, 8, 1, 0, 2, make([]byte, 64)...),
+			expected: "entry 0 at offset 22: truncated image data",
+		},
+		{
+			name:     "RLE without AND mask",
+			b:        newBMPIcon(4, 2, 8, 1, 8, 2, make([]byte, 16)...),
+			expected: "entry 0 at offset 22: truncated image data",
+		},
+		{
+			name:     "non-contiguous bit mask",
+			b:        newBitFieldsIcon(40, 16, [4]uint32{0xF0F0, 0xF00, 0xF}, 0, 0, 0, 0),
+			expected: "entry 0 at offset 22: invalid bit mask: 0xf0f0",
+		},
+		{
+			name:     "bit mask exceeding bit depth",
+			b:        newBitFieldsIcon(108, 16, [4]uint32{0x7C00, 0x3E0, 0x1F, 0x10000}, 0, 0, 0, 0),
+			expected: "entry 0 at offset 22: invalid bit mask: 0x10000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, d := range []*icondir.Decoder{
+				icondir.NewDecoder(bytes.NewReader(test.b), true),
+				icondir.NewDecoderAt(bytes.NewReader(test.b), int64(len(test.b)), true),
+			} {
+				if err := d.DecodeDir(); err == nil || err.Error() != test.expected {
+					t.Fatalf("Decoder.DecodeDir() = %v; want %s", err, test.expected)
+				}
+			}
+		})
+	}
+}
+
+// newBitFieldsIcon returns an icon with a 2x2 BI_BITFIELDS BMP image
+// of the top-down pixels and an empty AND mask.
+func newBitFieldsIcon(infoLen, bpp int, masks [4]uint32, pixels ...uint32) []byte {
+	h := make([]byte, infoLen)
+	binary.LittleEndian.PutUint32(h, uint32(infoLen))
+	binary.LittleEndian.PutUint32(h[4:], 2)
+	binary.LittleEndian.PutUint32(h[8:], 4)
+	binary.LittleEndian.PutUint16(h[12:], 1)
+	binary.LittleEndian.PutUint16(h[14:], uint16(bpp))
+	binary.LittleEndian.PutUint32(h[16:], 3)
+	if infoLen == 40 {
+		h = append(h, make([]byte, 4*3)...)
+	}
+	for i, mask := range masks {
+		if i < 3 || infoLen > 40 {
+			binary.LittleEndian.PutUint32(h[40+4*i:], mask)
+		}
+	}
+	data := h
+	for y := 1; y >= 0; y-- {
+		row := make([]byte, 4*((2*bpp+31)/32))
+		for x := 0; x < 2; x++ {
+			if bpp == 16 {
+				binary.LittleEndian.PutUint16(row[2*x:], uint16(pixels[2*y+x]))
+			} else {
+				binary.LittleEndian.PutUint32(row[4*x:], pixels[2*y+x])
+			}
+		}
+		data = append(data, row...)
+	}
+	data = append(data, make([]byte, 4*2)...)
+	b := make([]byte, 6+16, 6+16+len(data))
+	b[2], b[4] = 1, 1
+	binary.LittleEndian.PutUint32(b[14:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[18:], 6+16)
+	return append(b, data...)
+}
+
+func TestDecoder_DecodeBitFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        []byte
+		expected []color.NRGBA
+	}{
+		{
+			name: "BITMAPINFOHEADER RGB565",
+			b:    newBitFieldsIcon(40, 16, [4]uint32{0xF800, 0x7E0, 0x1F}, 0xF800, 0x7E0, 0x1F, 0xFFFF),
+			expected: []color.NRGBA{
+				{0xFF, 0, 0, 0xFF}, {0, 0xFF, 0, 0xFF},
+				{0, 0, 0xFF, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+			},
+		},
+		{
+			name: "BITMAPV4HEADER ARGB1555",
+			b:    newBitFieldsIcon(108, 16, [4]uint32{0x7C00, 0x3E0, 0x1F, 0x8000}, 0xFC00, 0x7C00, 0x8010, 0xFFFF),
+			expected: []color.NRGBA{
+				{0xFF, 0, 0, 0xFF}, {0xFF, 0, 0, 0},
+				{0, 0, 0x84, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+			},
+		},
+		{
+			name: "BITMAPV4HEADER without alpha mask",
+			b:    newBitFieldsIcon(108, 32, [4]uint32{0xFF0000, 0xFF00, 0xFF}, 0x00112233, 0x80112233, 0, 0xFFFFFFFF),
+			expected: []color.NRGBA{
+				{0x11, 0x22, 0x33, 0xFF}, {0x11, 0x22, 0x33, 0xFF},
+				{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+			},
+		},
+		{
+			name: "BITMAPV5HEADER ABGR",
+			b:    newBitFieldsIcon(124, 32, [4]uint32{0xFF, 0xFF00, 0xFF0000, 0xFF000000}, 0x80332211, 0xFF000000, 0x00FFFFFF, 0x7F7F7F7F),
+			expected: []color.NRGBA{
+				{0x11, 0x22, 0x33, 0x80}, {0, 0, 0, 0xFF},
+				{0xFF, 0xFF, 0xFF, 0}, {0x7F, 0x7F, 0x7F, 0x7F},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := icondir.NewDecoder(bytes.NewReader(test.b), true)
+			if err := d.DecodeDir(); err != nil {
+				t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+			}
+			m, err := d.Decode(d.Entries()[0])
+			if err != nil {
+				t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+			}
+			for i, expected := range test.expected {
+				x, y := i%2, i/2
+				if actual := color.NRGBAModel.Convert(m.At(x, y)); actual != expected {
+					t.Errorf("At(%d, %d) = %v; want %v", x, y, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+// newBMPIcon returns an icon with a BMP image of the given info header fields
+// followed by data.
+func newBMPIcon(width, height int32, bpp uint16, compression, sizeImage, colors uint32, data ...byte) []byte {
+	h := make([]byte, 40)
+	binary.LittleEndian.PutUint32(h, 40)
+	binary.LittleEndian.PutUint32(h[4:], uint32(width))
+	binary.LittleEndian.PutUint32(h[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(h[12:], 1)
+	binary.LittleEndian.PutUint16(h[14:], bpp)
+	binary.LittleEndian.PutUint32(h[16:], compression)
+	binary.LittleEndian.PutUint32(h[20:], sizeImage)
+	binary.LittleEndian.PutUint32(h[32:], colors)
+	b := make([]byte, 6+16, 6+16+len(h)+len(data))
+	b[2], b[4] = 1, 1
+	binary.LittleEndian.PutUint32(b[14:], uint32(len(h)+len(data)))
+	binary.LittleEndian.PutUint32(b[18:], 6+16)
+	return append(append(b, h...), data...)
+}
+
+func TestDecoder_DecodeUncommon(t *testing.T) {
+	var (
+		red         = color.NRGBA{0xFF, 0, 0, 0xFF}
+		blue        = color.NRGBA{0, 0, 0xFF, 0xFF}
+		white       = color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+		transparent = color.NRGBA{}
+		palette     = []byte{0, 0, 0xFF, 0, 0xFF, 0, 0, 0}
+	)
+	tests := []struct {
+		name     string
+		b        []byte
+		width    int
+		expected []color.NRGBA
+	}{
+		{
+			name: "RLE8",
+			b: newBMPIcon(4, 2, 8, 1, 18, 2, append(palette,
+				// Bottom row: a run of 4 pixels.
+				4, 1, 0, 0,
+				// Top row: 3 absolute pixels padded to a word, a run of 1 pixel.
+				0, 3, 0, 1, 0, 0, 1, 1,
+				0, 1,
+				// Padding up to biSizeImage.
+				0, 0, 0, 0,
+				// AND mask.
+				0, 0, 0, 0,
+				0x80, 0, 0, 0)...),
+			width: 4,
+			expected: []color.NRGBA{
+				transparent, blue, red, blue,
+				blue, blue, blue, blue,
+			},
+		},
+		{
+			name: "RLE4",
+			b: newBMPIcon(4, 2, 4, 2, 10, 2, append(palette,
+				// Bottom row: a run of 4 alternating pixels.
+				4, 0x10, 0, 0,
+				// Top row: 4 absolute pixels.
+				0, 4, 0x01, 0x10,
+				0, 1,
+				// AND mask.
+				0x10, 0, 0, 0,
+				0, 0, 0, 0)...),
+			width: 4,
+			expected: []color.NRGBA{
+				red, blue, blue, red,
+				blue, red, blue, transparent,
+			},
+		},
+		{
+			name: "2 BPP",
+			b: newBMPIcon(3, 2, 2, 0, 0, 0, append(palette,
+				0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0,
+				// Bottom row: pixels 1, 2, 3.
+				0x6C, 0, 0, 0,
+				// Top row: pixels 3, 0, 1.
+				0xC4, 0, 0, 0,
+				// AND mask.
+				0, 0, 0, 0,
+				0x40, 0, 0, 0)...),
+			width: 3,
+			expected: []color.NRGBA{
+				white, transparent, blue,
+				blue, {0, 0, 0, 0xFF}, white,
+			},
+		},
+		{
+			name: "RGB555",
+			b: newBMPIcon(3, 2, 16, 0, 0, 0,
+				0x1F, 0, 0xE0, 0x03, 0xFF, 0x7F, 0, 0,
+				0, 0x7C, 0x10, 0, 0, 0, 0, 0,
+				// AND mask.
+				0, 0, 0, 0,
+				0x20, 0, 0, 0),
+			width: 3,
+			expected: []color.NRGBA{
+				red, {0, 0, 0x84, 0xFF}, transparent,
+				blue, {0, 0xFF, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := icondir.NewDecoder(bytes.NewReader(test.b), true)
+			if err := d.DecodeDir(); err != nil {
+				t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+			}
+			m, err := d.Decode(d.Entries()[0])
+			if err != nil {
+				t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+			}
+			for i, expected := range test.expected {
+				x, y := i%test.width, i/test.width
+				if actual := color.NRGBAModel.Convert(m.At(x, y)); actual != expected {
+					t.Errorf("At(%d, %d) = %v; want %v", x, y, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeZeroAlpha(t *testing.T) {
+	tests := []struct {
+		name      string
+		b         []byte
+		maskFirst bool
+		expected  []color.NRGBA
+	}{
+		{
+			name: "zero alpha",
+			b: newBMPIcon(2, 1, 32, 0, 0, 0,
+				0x33, 0x22, 0x11, 0, 0x33, 0x22, 0x11, 0,
+				// AND mask.
+				0x40, 0, 0, 0),
+			expected: []color.NRGBA{{0x11, 0x22, 0x33, 0xFF}, {}},
+		},
+		{
+			name: "alpha first",
+			b: newBMPIcon(2, 1, 32, 0, 0, 0,
+				0x33, 0x22, 0x11, 0x80, 0x33, 0x22, 0x11, 0,
+				// AND mask.
+				0, 0, 0, 0),
+			expected: []color.NRGBA{{0x11, 0x22, 0x33, 0x80}, {0x11, 0x22, 0x33, 0}},
+		},
+		{
+			name: "mask first",
+			b: newBMPIcon(2, 1, 32, 0, 0, 0,
+				0x33, 0x22, 0x11, 0x80, 0x33, 0x22, 0x11, 0xFF,
+				// AND mask.
+				0x40, 0, 0, 0),
+			maskFirst: true,
+			expected:  []color.NRGBA{{0x11, 0x22, 0x33, 0xFF}, {}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := icondir.NewDecoder(bytes.NewReader(test.b), true)
+			d.MaskFirst = test.maskFirst
+			if err := d.DecodeDir(); err != nil {
+				t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+			}
+			m, err := d.Decode(d.Entries()[0])
+			if err != nil {
+				t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+			}
+			for x, expected := range test.expected {
+				if actual := color.NRGBAModel.Convert(m.At(x, 0)); actual != expected {
+					t.Errorf("At(%d, 0) = %v; want %v", x, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_Match(t *testing.T) {
+	d := icondir.NewDecoder(bytes.NewReader(testutil.Icon.MustRead()), true)
+	if err := d.DecodeDir(); err != nil {
+		t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+	}
+	tests := []struct {
+		width, height, bpp            int
+		expectedWidth, expectedHeight int
+		expectedBPP                   int
+	}{
+		{16, 16, 32, 16, 16, 32},
+		{16, 16, 8, 16, 16, 8},
+		{16, 16, 2, 16, 16, 1},
+		{16, 16, 0, 16, 16, 1},
+		{20, 20, 32, 32, 32, 32},
+		{48, 48, 24, 64, 64, 24},
+		{64, 64, 16, 64, 64, 8},
+		{300, 300, 32, 256, 256, 32},
+		{300, 300, 24, 256, 256, 32},
+	}
+	for _, test := range tests {
+		e := d.Match(test.width, test.height, test.bpp)
+		if e.Width != test.expectedWidth || e.Height != test.expectedHeight || e.BPP != test.expectedBPP {
+			t.Errorf("Decoder.Match(%d, %d, %d) = %dx%d-%d; want %dx%d-%d", test.width, test.height, test.bpp, e.Width, e.Height, e.BPP, test.expectedWidth, test.expectedHeight, test.expectedBPP)
+		}
+	}
 }
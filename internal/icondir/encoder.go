@@ -10,7 +10,7 @@ import (
 	"io"
 	"strconv"
 
-	"github.com/sergeymakinen/go-bmp"
+	"github.com/sergeymakinen/go-ico/internal/quantize"
 )
 
 type Encoder struct {
@@ -26,16 +26,100 @@ func NewEncoder(w io.Writer, icon bool) *Encoder {
 	}
 }
 
+type Format int
+
+const (
+	FormatPNGAt256 Format = iota
+	FormatBMP
+	FormatPNG
+	FormatSmallest
+)
+
+type Options struct {
+	BPP    int
+	Dither quantize.Dither
+	Format Format
+	Large  bool
+
+	// InfoHeaderLen is the length of the BMP info header: 40, 108 or 124.
+	// If InfoHeaderLen is 0, 40 is used.
+	InfoHeaderLen int
+
+	// Mask, if not nil, is the AND mask stored instead of the one made of
+	// the fully transparent pixels. The image is then always stored as BMP.
+	Mask image.Image
+
+	// AlphaThreshold, if positive, makes the pixels of BMP images with
+	// the alpha less than AlphaThreshold fully transparent. 32-bit images
+	// keep their alpha channel untouched.
+	AlphaThreshold uint8
+
+	// ColorKey, if not nil, is the color made fully transparent.
+	ColorKey color.Color
+
+	// KeepPalette stores paletted images with up to 8 BPP with their palette
+	// and indices unchanged except for the trailing fully transparent colors.
+	KeepPalette bool
+
+	// TwoBPP lets the bit depth chosen from the image type be 2
+	// for palettes of 3 or 4 opaque colors.
+	TwoBPP bool
+}
+
 func (e *Encoder) Add(m image.Image, xHotspot, yHotspot int) error {
+	return e.AddOptions(m, xHotspot, yHotspot, Options{})
+}
+
+func (e *Encoder) AddOptions(m image.Image, xHotspot, yHotspot int, o Options) error {
+	entry, err := NewEntry(m, xHotspot, yHotspot, o)
+	if err != nil {
+		return err
+	}
+	e.entries = append(e.entries, entry)
+	return nil
+}
+
+// AddRaw adds the BMP or PNG data b as is.
+func (e *Encoder) AddRaw(b []byte, xHotspot, yHotspot int) error {
+	entry, err := NewRawEntry(b, xHotspot, yHotspot)
+	if err != nil {
+		return err
+	}
+	e.entries = append(e.entries, entry)
+	return nil
+}
+
+// AddEntry adds the entry created by NewEntry or NewRawEntry.
+func (e *Encoder) AddEntry(entry *Entry) {
+	e.entries = append(e.entries, entry)
+}
+
+// NewEntry returns an entry with m encoded as a BMP or PNG image.
+func NewEntry(m image.Image, xHotspot, yHotspot int, o Options) (*Entry, error) {
 	d := m.Bounds().Size()
-	if d.X < 1 || d.Y < 1 || d.X > 256 || d.Y > 256 {
-		return FormatError("invalid image size: " + strconv.Itoa(d.X) + "x" + strconv.Itoa(d.Y))
+	large := d.X > 256 || d.Y > 256
+	if d.X < 1 || d.Y < 1 || (large && !o.Large) {
+		return nil, FormatError("invalid image size: " + strconv.Itoa(d.X) + "x" + strconv.Itoa(d.Y))
 	}
 	if m, ok := m.(*image.Paletted); ok && (len(m.Palette) == 0 || len(m.Palette) > 256) {
-		return FormatError("bad palette length: " + strconv.Itoa(len(m.Palette)))
+		return nil, FormatError("bad palette length: " + strconv.Itoa(len(m.Palette)))
 	}
-	if xHotspot < 0 || yHotspot < 0 || xHotspot > 256 || yHotspot > 256 {
-		return FormatError("invalid hotspot: " + strconv.Itoa(xHotspot) + "x" + strconv.Itoa(yHotspot))
+	if xHotspot < 0 || yHotspot < 0 || xHotspot >= d.X || yHotspot >= d.Y {
+		return nil, FormatError("invalid hotspot: " + strconv.Itoa(xHotspot) + "x" + strconv.Itoa(yHotspot))
+	}
+	switch o.BPP {
+	case 0, 1, 2, 4, 8, 24, 32:
+	default:
+		return nil, UnsupportedError("bit depth " + strconv.Itoa(o.BPP))
+	}
+	switch o.InfoHeaderLen {
+	case 0, bmpInfoHeaderLen, bmpV4InfoHeaderLen, bmpV5InfoHeaderLen:
+	default:
+		return nil, UnsupportedError("BMP info header length " + strconv.Itoa(o.InfoHeaderLen))
+	}
+	if o.ColorKey != nil {
+		k := color.NRGBAModel.Convert(o.ColorKey).(color.NRGBA)
+		m = transparentFunc(m, func(c color.NRGBA) bool { return c.A != 0 && c.R == k.R && c.G == k.G && c.B == k.B })
 	}
 	entry := &Entry{
 		Width:    d.X,
@@ -43,83 +127,123 @@ func (e *Encoder) Add(m image.Image, xHotspot, yHotspot int) error {
 		XHotspot: xHotspot,
 		YHotspot: yHotspot,
 	}
-	isPNG := false
-	if d.X == 256 && d.Y == 256 {
-		switch m := m.(type) {
-		case *image.Paletted:
-			entry.Colors = len(m.Palette)
-		case *image.Gray:
-			entry.Colors = 256
-		default:
-			isPNG = true
-		}
+	if p, ok := o.Mask.(*image.Paletted); ok && p == nil {
+		// The nil mask of a PNG image returned by DecodeMask.
+		o.Mask = nil
 	}
-	m2 := m
-	var buf bytes.Buffer
-	if isPNG {
-		// Icon's PNGs are always expected to be 32 bit.
-		if rgba, ok := m.(*image.RGBA); ok {
-			m2 = &nonOpaqueRGBA{rgba}
-		} else {
-			tmp := image.NewRGBA(m.Bounds())
-			draw.Draw(tmp, tmp.Bounds(), m, m.Bounds().Min, draw.Src)
-			m2 = &nonOpaqueRGBA{tmp}
+	if o.Mask != nil {
+		if md := o.Mask.Bounds().Size(); md != d {
+			return nil, FormatError("mismatched mask size: " + strconv.Itoa(md.X) + "x" + strconv.Itoa(md.Y))
 		}
-		if err := png.Encode(&buf, m2); err != nil {
-			return err
+		if large {
+			return nil, UnsupportedError("AND mask for images larger than 256x256")
 		}
-		entry.data = buf.Bytes()
+	}
+	var data []byte
+	var err error
+	if large {
+		// Only PNG images can be larger than 256x256.
+		if o.BPP != 0 && o.BPP != 32 {
+			return nil, UnsupportedError("bit depth " + strconv.Itoa(o.BPP) + " for images larger than 256x256")
+		}
+		data, err = pngData(m)
+	} else if (o.BPP != 0 && o.BPP != 32) || o.Mask != nil {
+		// Other depths and masks are only available for BMP images.
+		data, err = bmpData(m, o)
 	} else {
-		if paletted, ok := m.(*image.Paletted); ok {
-			// Remove transparent color from palette.
-			var p color.Palette
-			for _, c := range paletted.Palette {
-				if _, _, _, a := c.RGBA(); a != 0 {
-					p = append(p, c)
-				}
+		switch o.Format {
+		case FormatBMP:
+			data, err = bmpData(m, o)
+		case FormatPNG:
+			data, err = pngData(m)
+		case FormatSmallest:
+			if data, err = bmpData(m, o); err != nil {
+				return nil, err
 			}
-			redraw := len(p) != len(paletted.Palette)
-			if n := len(p); n == 3 || n == 4 {
-				// 2 BPP images are not supported.
-				p = append(p, []color.Color{color.Black, color.Black}[:5-n]...)
-				redraw = true
+			// Compare the encodings of the same pixels.
+			pm := m
+			if o.AlphaThreshold > 0 && !keepsAlpha(m, o.BPP) {
+				pm = o.threshold(m)
 			}
-			if redraw {
-				tmp := image.NewPaletted(m.Bounds(), p)
-				drawOpaque(tmp, m)
-				m2 = tmp
-				entry.Colors = len(tmp.Palette)
+			var data2 []byte
+			if data2, err = pngData(pm); err == nil && len(data2) < len(data) {
+				data = data2
 			}
-		} else if _, ok := m.(*image.Gray); !ok {
-			if opaque, semiopaque := opaque(m); !opaque && !semiopaque {
-				tmp := image.NewRGBA(m.Bounds())
-				draw.Draw(tmp, tmp.Bounds(), image.Black, image.Point{}, draw.Src)
-				draw.Draw(tmp, tmp.Bounds(), m, m.Bounds().Min, draw.Over)
-				m2 = tmp
+		default:
+			isPNG := false
+			if d.X == 256 && d.Y == 256 {
+				switch m.(type) {
+				case *image.Paletted, *image.Gray:
+					isPNG = o.BPP == 32
+				default:
+					isPNG = true
+				}
+			}
+			if isPNG {
+				data, err = pngData(m)
+			} else {
+				data, err = bmpData(m, o)
 			}
 		}
-		if err := bmp.Encode(&buf, m2); err != nil {
-			return err
-		}
-		entry.bmpHeader = buf.Bytes()[:bmpFileHeaderLen]
-		n, err := encodeMask(&buf, m)
-		if err != nil {
-			return err
-		}
-		entry.data = buf.Bytes()[bmpFileHeaderLen:]
-		// Fix height.
-		binary.LittleEndian.PutUint32(entry.data[8:], uint32(entry.Height*2))
-		// Add mask size to image size.
-		size := binary.LittleEndian.Uint32(entry.data[20:])
-		binary.LittleEndian.PutUint32(entry.data[20:], size+uint32(n))
 	}
-	tmp := &Entry{}
+	if err != nil {
+		return nil, err
+	}
+	entry.data = data
+	tmp := &Entry{Size: int64(len(entry.data))}
 	if err := decodeHeader(bytes.NewReader(entry.data), tmp); err != nil {
-		return err
+		return nil, err
 	}
 	entry.Colors, entry.BPP, entry.Size = tmp.Colors, tmp.BPP, int64(len(entry.data))
-	e.entries = append(e.entries, entry)
-	return nil
+	entry.bmpHeader, entry.headerLen, entry.masks, entry.rleLen = tmp.bmpHeader, tmp.headerLen, tmp.masks, tmp.rleLen
+	return entry, nil
+}
+
+// NewRawEntry returns an entry with the BMP or PNG data b stored as is.
+func NewRawEntry(b []byte, xHotspot, yHotspot int) (*Entry, error) {
+	entry := &Entry{
+		XHotspot: xHotspot,
+		YHotspot: yHotspot,
+		Size:     int64(len(b)),
+		data:     b,
+	}
+	if err := decodeHeader(bytes.NewReader(b), entry); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = FormatError("truncated image data")
+		}
+		return nil, err
+	}
+	if entry.Width < 1 || entry.Height < 1 {
+		return nil, FormatError("invalid image size: " + strconv.Itoa(entry.Width) + "x" + strconv.Itoa(entry.Height))
+	}
+	if xHotspot < 0 || yHotspot < 0 || xHotspot >= entry.Width || yHotspot >= entry.Height {
+		return nil, FormatError("invalid hotspot: " + strconv.Itoa(xHotspot) + "x" + strconv.Itoa(yHotspot))
+	}
+	return entry, nil
+}
+
+func pngData(m image.Image) ([]byte, error) {
+	// Icon's PNGs are always expected to be 32 bit.
+	switch m.(type) {
+	case *image.RGBA, *image.NRGBA:
+	default:
+		tmp := image.NewNRGBA(m.Bounds())
+		draw.Draw(tmp, tmp.Bounds(), m, m.Bounds().Min, draw.Src)
+		m = tmp
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, &nonOpaque{m}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bmpData(m image.Image, o Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeBMP(&buf, m, o); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func (e *Encoder) Encode() error {
@@ -179,16 +303,28 @@ func (e *Encoder) Encode() error {
 	return nil
 }
 
-func encodeMask(w io.Writer, m image.Image) (n int, err error) {
-	d := m.Bounds().Size()
+// newMask returns the AND mask of m. If explicit is not nil, the mask bits
+// are set where its pixels are lighter than 50% gray, otherwise where
+// the pixels of m are fully transparent.
+func newMask(m, explicit image.Image) *image.Paletted {
 	mask := image.NewPaletted(m.Bounds(), maskPalette)
 	for y := m.Bounds().Min.Y; y < m.Bounds().Max.Y; y++ {
 		for x := m.Bounds().Min.X; x < m.Bounds().Max.X; x++ {
-			if _, _, _, a := m.At(x, y).RGBA(); a == 0 {
-				mask.Set(x, y, color.Opaque)
+			if explicit != nil {
+				c := explicit.At(x-m.Bounds().Min.X+explicit.Bounds().Min.X, y-m.Bounds().Min.Y+explicit.Bounds().Min.Y)
+				if color.Gray16Model.Convert(c).(color.Gray16).Y >= 0x8000 {
+					mask.SetColorIndex(x, y, 1)
+				}
+			} else if _, _, _, a := m.At(x, y).RGBA(); a == 0 {
+				mask.SetColorIndex(x, y, 1)
 			}
 		}
 	}
+	return mask
+}
+
+func encodeMask(w io.Writer, mask *image.Paletted) (n int, err error) {
+	d := mask.Bounds().Size()
 	// There is 1 bit per pixel, and each row is 4-byte aligned.
 	b := make([]byte, ((d.X+8-1)/8+3)&^3)
 	for y := d.Y - 1; y >= 0; y-- {
@@ -212,17 +348,285 @@ func encodeMask(w io.Writer, m image.Image) (n int, err error) {
 	return
 }
 
-func drawOpaque(dst draw.Image, src image.Image) {
-	for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
-		for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
-			c := src.At(x, y)
-			if _, _, _, a := src.At(x, y).RGBA(); a != 0 {
-				dst.Set(x, y, c)
+// encodeBMP writes m to w as a BMP image without the file header followed by
+// the AND mask. If o.BPP is 0, the color depth is chosen from the image type.
+func encodeBMP(w io.Writer, m image.Image, o Options) error {
+	d := m.Bounds().Size()
+	if o.AlphaThreshold > 0 && !keepsAlpha(m, o.BPP) {
+		m = o.threshold(m)
+	}
+	var paletted *image.Paletted
+	var bpp int
+	if p, ok := m.(*image.Paletted); ok && o.KeepPalette && o.BPP <= 8 {
+		var err error
+		if paletted, bpp, err = keepPalette(p, o.BPP, o.TwoBPP); err != nil {
+			return err
+		}
+	} else {
+		paletted, bpp = bmpPaletted(m, o.BPP, o.TwoBPP, o.Dither)
+	}
+	var step int
+	if paletted != nil {
+		step = ((d.X*bpp+8-1)/8 + 3) &^ 3
+	} else {
+		step = (d.X*bpp/8 + 3) &^ 3
+	}
+	maskStep := ((d.X+8-1)/8 + 3) &^ 3
+	infoLen := o.InfoHeaderLen
+	if infoLen == 0 {
+		infoLen = bmpInfoHeaderLen
+	}
+	h := struct {
+		infoLen         uint32
+		width           int32
+		height          int32
+		colorPlane      uint16
+		bpp             uint16
+		compression     uint32
+		imageSize       uint32
+		xPixelsPerMeter uint32
+		yPixelsPerMeter uint32
+		colorUse        uint32
+		colorImportant  uint32
+	}{
+		infoLen: uint32(infoLen),
+		width:   int32(d.X),
+		// The height includes the AND mask.
+		height:     int32(d.Y * 2),
+		colorPlane: 1,
+		bpp:        uint16(bpp),
+		imageSize:  uint32((step + maskStep) * d.Y),
+	}
+	if paletted != nil && len(paletted.Palette) < 1<<bpp {
+		h.colorUse = uint32(len(paletted.Palette))
+	}
+	if infoLen > bmpInfoHeaderLen && bpp == 32 {
+		// Make the alpha channel explicit.
+		h.compression = biBitFields
+	}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	if infoLen > bmpInfoHeaderLen {
+		const (
+			lcsSRGB     = 0x73524742
+			lcsGMImages = 4
+		)
+		// The masks are followed by the color space, its endpoints and gamma,
+		// and BITMAPV5HEADER adds the rendering intent and profile fields.
+		b := make([]byte, infoLen-bmpInfoHeaderLen)
+		if h.compression == biBitFields {
+			for i, mask := range []uint32{0xFF0000, 0xFF00, 0xFF, 0xFF000000} {
+				binary.LittleEndian.PutUint32(b[4*i:], mask)
 			}
 		}
+		binary.LittleEndian.PutUint32(b[16:], lcsSRGB)
+		if infoLen == bmpV5InfoHeaderLen {
+			binary.LittleEndian.PutUint32(b[68:], lcsGMImages)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
 	}
+	if paletted != nil {
+		palette := make([]byte, 4*len(paletted.Palette))
+		for i, c := range paletted.Palette {
+			c := color.NRGBAModel.Convert(c).(color.NRGBA)
+			// BMP images are stored in BGR order rather than RGB order.
+			palette[4*i+0], palette[4*i+1], palette[4*i+2] = c.B, c.G, c.R
+		}
+		if _, err := w.Write(palette); err != nil {
+			return err
+		}
+	}
+	b := make([]byte, step)
+	for y := m.Bounds().Max.Y - 1; y >= m.Bounds().Min.Y; y-- {
+		for i := range b {
+			b[i] = 0
+		}
+		for x := m.Bounds().Min.X; x < m.Bounds().Max.X; x++ {
+			i := x - m.Bounds().Min.X
+			if paletted != nil {
+				pixelsPerByte := 8 / bpp
+				shift := uint(8 - bpp*(i%pixelsPerByte+1))
+				b[i/pixelsPerByte] |= paletted.Pix[paletted.PixOffset(x, y)] << shift
+				continue
+			}
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			// BMP images are stored in BGR(A) order rather than RGB(A) order.
+			p := b[i*bpp/8:]
+			p[0], p[1], p[2] = c.B, c.G, c.R
+			if bpp == 32 {
+				p[3] = c.A
+			}
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := encodeMask(w, newMask(m, o.Mask))
+	return err
 }
 
+// bmpPaletted returns the paletted image to store as a bpp BMP image or nil
+// if the image is stored in true color. If bpp is 0, it is chosen
+// from the image type, and it's 2 only if twoBPP is true. Quantized images
+// are dithered with the method d.
+func bmpPaletted(m image.Image, bpp int, twoBPP bool, d quantize.Dither) (*image.Paletted, int) {
+	switch m := m.(type) {
+	case *image.Paletted:
+		// Transparent colors are stored in the AND mask.
+		var p color.Palette
+		for _, c := range m.Palette {
+			if _, _, _, a := c.RGBA(); a != 0 {
+				p = append(p, c)
+			}
+		}
+		if bpp == 0 {
+			switch {
+			case len(p) <= 2:
+				bpp = 1
+			case len(p) <= 4 && twoBPP:
+				// 2 BPP images are only chosen on request as few readers support them.
+				bpp = 2
+			case len(p) <= 16:
+				bpp = 4
+			default:
+				bpp = 8
+			}
+		}
+		if bpp <= 8 && len(p) <= 1<<bpp {
+			if len(p) == len(m.Palette) {
+				return m, bpp
+			}
+			if len(p) == 0 {
+				p = color.Palette{color.Black}
+			}
+			return quantize.Map(m, p, quantize.None), bpp
+		}
+	case *image.Gray:
+		if bpp == 0 || bpp == 8 {
+			return &image.Paletted{
+				Pix:     m.Pix,
+				Stride:  m.Stride,
+				Rect:    m.Rect,
+				Palette: grayPalette,
+			}, 8
+		}
+	default:
+		if bpp == 0 {
+			bpp = 24
+			if _, semiopaque := opaque(m); semiopaque {
+				bpp = 32
+			}
+		}
+	}
+	if bpp > 8 {
+		return nil, bpp
+	}
+	p := quantize.MedianCut(m, 1<<bpp)
+	if len(p) == 0 {
+		p = color.Palette{color.Black}
+	}
+	return quantize.Map(m, p, d), bpp
+}
+
+// keepPalette returns m with the trailing fully transparent colors,
+// which are stored in the AND mask, removed from the palette, and the bit depth
+// fitting the palette. If bpp is not 0, the palette must fit it.
+// The fitting bit depth is 2 only if twoBPP is true.
+func keepPalette(m *image.Paletted, bpp int, twoBPP bool) (*image.Paletted, int, error) {
+	n := len(m.Palette)
+	for n > 1 {
+		if _, _, _, a := m.Palette[n-1].RGBA(); a != 0 {
+			break
+		}
+		n--
+	}
+	if bpp == 0 {
+		switch {
+		case n <= 2:
+			bpp = 1
+		case n <= 4 && twoBPP:
+			bpp = 2
+		case n <= 16:
+			bpp = 4
+		default:
+			bpp = 8
+		}
+	}
+	if n > 1<<bpp {
+		return nil, 0, UnsupportedError("palette of " + strconv.Itoa(n) + " colors at bit depth " + strconv.Itoa(bpp))
+	}
+	if n == len(m.Palette) {
+		return m, bpp, nil
+	}
+	tmp := *m
+	tmp.Palette = m.Palette[:n]
+	tmp.Pix = make([]uint8, len(m.Pix))
+	for i, c := range m.Pix {
+		if int(c) < n {
+			tmp.Pix[i] = c
+		}
+	}
+	return &tmp, bpp, nil
+}
+
+// threshold returns m with the pixels with the alpha less than o.AlphaThreshold
+// made fully transparent.
+func (o Options) threshold(m image.Image) image.Image {
+	return transparentFunc(m, func(c color.NRGBA) bool { return c.A < o.AlphaThreshold })
+}
+
+// keepsAlpha reports whether m is stored as a 32-bit BMP image with
+// its alpha channel. If bpp is 0, it is chosen from the image type.
+func keepsAlpha(m image.Image, bpp int) bool {
+	if bpp != 0 {
+		return bpp == 32
+	}
+	switch m.(type) {
+	case *image.Paletted, *image.Gray:
+		return false
+	}
+	_, semiopaque := opaque(m)
+	return semiopaque
+}
+
+// transparentFunc returns a copy of m with the pixels for which f returns true
+// made fully transparent. Paletted images stay paletted.
+func transparentFunc(m image.Image, f func(c color.NRGBA) bool) image.Image {
+	if m, ok := m.(*image.Paletted); ok {
+		tmp := *m
+		tmp.Palette = make(color.Palette, len(m.Palette))
+		for i, c := range m.Palette {
+			if f(color.NRGBAModel.Convert(c).(color.NRGBA)) {
+				c = color.Transparent
+			}
+			tmp.Palette[i] = c
+		}
+		return &tmp
+	}
+	tmp := image.NewNRGBA(m.Bounds())
+	draw.Draw(tmp, tmp.Bounds(), m, m.Bounds().Min, draw.Src)
+	for i := 0; i < len(tmp.Pix); i += 4 {
+		if f(color.NRGBA{R: tmp.Pix[i], G: tmp.Pix[i+1], B: tmp.Pix[i+2], A: tmp.Pix[i+3]}) {
+			tmp.Pix[i], tmp.Pix[i+1], tmp.Pix[i+2], tmp.Pix[i+3] = 0, 0, 0, 0
+		}
+	}
+	return tmp
+}
+
+var grayPalette = func() color.Palette {
+	p := make(color.Palette, 256)
+	for i := range p {
+		p[i] = color.Gray{Y: uint8(i)}
+	}
+	return p
+}()
+
 type opaquer interface {
 	Opaque() bool
 }
@@ -246,8 +650,8 @@ func opaque(m image.Image) (opaque, semiopaque bool) {
 	return
 }
 
-type nonOpaqueRGBA struct {
-	*image.RGBA
+type nonOpaque struct {
+	image.Image
 }
 
-func (*nonOpaqueRGBA) Opaque() bool { return false }
+func (*nonOpaque) Opaque() bool { return false }
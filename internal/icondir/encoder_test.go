@@ -2,11 +2,15 @@ package icondir_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"image"
+	"image/color"
 	"image/draw"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/sergeymakinen/go-ico/internal/icondir"
@@ -43,7 +47,7 @@ func TestEncoder_EncodeCursor(t *testing.T) {
 	var buf bytes.Buffer
 	e := icondir.NewEncoder(&buf, false)
 	for _, entry := range testutil.Cursor.Entries {
-		if err := e.Add(entry.MustDecode(), 0, 0); err != nil {
+		if err := e.Add(entry.MustDecode(), entry.XHotspot, entry.YHotspot); err != nil {
 			t.Fatalf("Encoder.Add() = %v; want nil", err)
 		}
 	}
@@ -116,3 +120,244 @@ func TestEncoder_EncodePNG32Bit(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoder_AddOptionsBPP(t *testing.T) {
+	src := testutil.Icon.Entries[13].MustDecode()
+	for _, bpp := range []int{1, 2, 4, 8, 24, 32} {
+		t.Run(strconv.Itoa(bpp), func(t *testing.T) {
+			var buf bytes.Buffer
+			e := icondir.NewEncoder(&buf, true)
+			if err := e.AddOptions(src, 0, 0, icondir.Options{BPP: bpp}); err != nil {
+				t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+			}
+			if err := e.Encode(); err != nil {
+				t.Fatalf("Encoder.Encode() = %v; want nil", err)
+			}
+			d := icondir.NewDecoder(&buf, true)
+			if err := d.DecodeDir(); err != nil {
+				t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+			}
+			entries, mm, err := d.DecodeAll()
+			if err != nil {
+				t.Fatalf("Decoder.DecodeAll() = _, _, %v; want nil", err)
+			}
+			if entries[0].BPP != bpp {
+				t.Errorf("Entry.BPP = %d; want %d", entries[0].BPP, bpp)
+			}
+			if bpp <= 8 && entries[0].Colors > 1<<bpp {
+				t.Errorf("Entry.Colors = %d; want <= %d", entries[0].Colors, 1<<bpp)
+			}
+			for y := 0; y < 32; y++ {
+				for x := 0; x < 32; x++ {
+					_, _, _, a1 := src.At(x, y).RGBA()
+					_, _, _, a2 := mm[0].At(x, y).RGBA()
+					if (a1 == 0) != (a2 == 0) {
+						t.Fatalf("At(%d, %d).A = %d; want transparent = %t", x, y, a2, a1 == 0)
+					}
+				}
+			}
+			if bpp == 32 {
+				testutil.Compare(t, src, mm[0])
+			}
+		})
+	}
+}
+
+func TestEncoder_AddOptionsInfoHeaderLen(t *testing.T) {
+	src := testutil.Icon.Entries[13].MustDecode()
+	for _, infoLen := range []int{40, 108, 124} {
+		for _, bpp := range []int{8, 24, 32} {
+			t.Run(strconv.Itoa(infoLen)+"/"+strconv.Itoa(bpp), func(t *testing.T) {
+				var buf bytes.Buffer
+				e := icondir.NewEncoder(&buf, true)
+				if err := e.AddOptions(src, 0, 0, icondir.Options{BPP: bpp, InfoHeaderLen: infoLen}); err != nil {
+					t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+				}
+				if err := e.Encode(); err != nil {
+					t.Fatalf("Encoder.Encode() = %v; want nil", err)
+				}
+				if actual := int(binary.LittleEndian.Uint32(buf.Bytes()[6+16:])); actual != infoLen {
+					t.Errorf("info header length = %d; want %d", actual, infoLen)
+				}
+				d := icondir.NewDecoder(&buf, true)
+				if err := d.DecodeDir(); err != nil {
+					t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+				}
+				entries, mm, err := d.DecodeAll()
+				if err != nil {
+					t.Fatalf("Decoder.DecodeAll() = _, _, %v; want nil", err)
+				}
+				if entries[0].BPP != bpp {
+					t.Errorf("Entry.BPP = %d; want %d", entries[0].BPP, bpp)
+				}
+				if bpp == 32 {
+					testutil.Compare(t, src, mm[0])
+				}
+			})
+		}
+	}
+}
+
+func TestEncoder_AddOptionsShouldFail(t *testing.T) {
+	e := icondir.NewEncoder(io.Discard, true)
+	err := e.AddOptions(image.NewGray(image.Rect(0, 0, 16, 16)), 0, 0, icondir.Options{BPP: 16})
+	if expected := "bit depth 16"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.AddOptions() = %v; want %s", err, expected)
+	}
+	err = e.AddOptions(image.NewGray(image.Rect(0, 0, 16, 16)), 0, 0, icondir.Options{InfoHeaderLen: 52})
+	if expected := "BMP info header length 52"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.AddOptions() = %v; want %s", err, expected)
+	}
+}
+
+func TestEncoder_AddOptionsFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   icondir.Format
+		bpp      int
+		expected []bool
+	}{
+		{"PNGAt256", icondir.FormatPNGAt256, 0, []bool{true, false}},
+		{"BMP", icondir.FormatBMP, 0, []bool{false, false}},
+		{"PNG", icondir.FormatPNG, 0, []bool{true, true}},
+		{"PNG with BPP", icondir.FormatPNG, 24, []bool{false, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			e := icondir.NewEncoder(&buf, true)
+			for _, i := range []int{11, 14} {
+				if err := e.AddOptions(testutil.Icon.Entries[i].MustDecode(), 0, 0, icondir.Options{BPP: test.bpp, Format: test.format}); err != nil {
+					t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+				}
+			}
+			if err := e.Encode(); err != nil {
+				t.Fatalf("Encoder.Encode() = %v; want nil", err)
+			}
+			d := icondir.NewDecoder(&buf, true)
+			if err := d.DecodeDir(); err != nil {
+				t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+			}
+			for i, e := range d.Entries() {
+				if e.PNG() != test.expected[i] {
+					t.Errorf("Entry.PNG() = %t; want %t", e.PNG(), test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncoder_AddOptionsFormatSmallest(t *testing.T) {
+	m := testutil.Icon.Entries[11].MustDecode()
+	var sizes [3]int64
+	for i, format := range []icondir.Format{icondir.FormatBMP, icondir.FormatPNG, icondir.FormatSmallest} {
+		var buf bytes.Buffer
+		e := icondir.NewEncoder(&buf, true)
+		if err := e.AddOptions(m, 0, 0, icondir.Options{Format: format}); err != nil {
+			t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+		}
+		if err := e.Encode(); err != nil {
+			t.Fatalf("Encoder.Encode() = %v; want nil", err)
+		}
+		sizes[i] = int64(buf.Len())
+	}
+	expected := sizes[0]
+	if sizes[1] < expected {
+		expected = sizes[1]
+	}
+	if sizes[2] != expected {
+		t.Errorf("size = %d; want %d", sizes[2], expected)
+	}
+}
+
+func TestEncoder_AddOptionsFormatSmallestAlphaThreshold(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 64, 64), color.Palette{color.NRGBA{0xFF, 0, 0, 0xFF}, color.NRGBA{0, 0, 0xFF, 0x40}})
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i % 2)
+	}
+	var buf bytes.Buffer
+	e := icondir.NewEncoder(&buf, true)
+	if err := e.AddOptions(m, 0, 0, icondir.Options{Format: icondir.FormatSmallest, AlphaThreshold: 128}); err != nil {
+		t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d := icondir.NewDecoder(&buf, true)
+	if err := d.DecodeDir(); err != nil {
+		t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+	}
+	m2, err := d.Decode(d.Entries()[0])
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	if _, _, _, a := m2.At(1, 0).RGBA(); a != 0 {
+		t.Errorf("At(1, 0).A = %d; want 0", a)
+	}
+}
+
+func TestEncoder_AddOptionsLarge(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 512, 768))
+	var buf bytes.Buffer
+	e := icondir.NewEncoder(&buf, true)
+	if err := e.AddOptions(m, 0, 0, icondir.Options{}); err == nil || err.Error() != "invalid image size: 512x768" {
+		t.Fatalf("Encoder.AddOptions() = %v; want invalid image size: 512x768", err)
+	}
+	if err := e.AddOptions(m, 0, 0, icondir.Options{BPP: 8, Large: true}); err == nil || err.Error() != "bit depth 8 for images larger than 256x256" {
+		t.Fatalf("Encoder.AddOptions() = %v; want bit depth 8 for images larger than 256x256", err)
+	}
+	if err := e.AddOptions(testutil.Icon.Entries[11].MustDecode(), 0, 0, icondir.Options{}); err != nil {
+		t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+	}
+	if err := e.AddOptions(m, 0, 0, icondir.Options{Format: icondir.FormatBMP, Large: true}); err != nil {
+		t.Fatalf("Encoder.AddOptions() = %v; want nil", err)
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	// The second directory entry starts at 6+16.
+	if b := buf.Bytes(); b[22] != 0 || b[23] != 0 {
+		t.Errorf("directory size = %dx%d; want 0x0", b[22], b[23])
+	}
+	d := icondir.NewDecoder(&buf, true)
+	if err := d.DecodeDir(); err != nil {
+		t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+	}
+	best, _ := d.Best()
+	if best.Width != 512 || best.Height != 768 || !best.PNG() {
+		t.Errorf("Decoder.Best() = %dx%d (PNG: %t); want 512x768 (PNG: true)", best.Width, best.Height, best.PNG())
+	}
+	m2, err := d.Decode(best)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, m, m2)
+}
+
+func TestEncoder_AddRawCursor(t *testing.T) {
+	b := testutil.Cursor.MustRead()
+	d := icondir.NewDecoder(bytes.NewReader(b), false)
+	if err := d.DecodeDir(); err != nil {
+		t.Fatalf("Decoder.DecodeDir() = %v; want nil", err)
+	}
+	var buf bytes.Buffer
+	e := icondir.NewEncoder(&buf, false)
+	for _, entry := range d.Entries() {
+		raw, err := d.ReadRaw(entry)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		if err := e.AddRaw(raw, entry.Width, 0); err == nil {
+			t.Fatalf("Encoder.AddRaw() = nil; want invalid hotspot")
+		}
+		if err := e.AddRaw(raw, entry.XHotspot, entry.YHotspot); err != nil {
+			t.Fatalf("Encoder.AddRaw() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("Encoder.Encode() wrote different data")
+	}
+}
@@ -0,0 +1,207 @@
+package icondir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strconv"
+)
+
+// recoverWindow is how far from the directory offset the image data is searched for.
+const recoverWindow = 64
+
+// Recover decodes as many images from the damaged ICO or CUR file b as possible.
+// It tolerates a wrong entry count and data sizes, looks for the image data
+// around the wrong offsets and scans the rest of b for images not listed
+// in the directory. The problems found are reported as issues.
+// Images exceeding the width and height limits are skipped.
+func Recover(b []byte, icon bool, limits Limits) ([]*Entry, []image.Image, []Issue) {
+	r := &recoverer{
+		b: b,
+		d: NewDecoderAt(bytes.NewReader(b), int64(len(b)), icon),
+	}
+	r.d.Limits = limits
+	count := 0
+	switch {
+	case len(b) < fileHeaderLen:
+		r.add(SeverityError, -1, 0, "truncated file header")
+	case icon && string(b[:4]) != icoPrefix:
+		r.add(SeverityError, -1, 0, "not an ICO file")
+	case !icon && string(b[:4]) != curPrefix:
+		r.add(SeverityError, -1, 0, "not a CUR file")
+	default:
+		count = int(binary.LittleEndian.Uint16(b[4:]))
+		if n := (len(b) - fileHeaderLen) / dirEntryLen; count > n {
+			r.add(SeverityWarning, -1, 4, strconv.Itoa(count)+" entries declared, only "+strconv.Itoa(n)+" fit into the file")
+			count = n
+		}
+	}
+	for i := 0; i < count; i++ {
+		off := int64(fileHeaderLen + i*dirEntryLen)
+		d := b[off : off+dirEntryLen]
+		e := &Entry{
+			Size:   int64(binary.LittleEndian.Uint32(d[8:])),
+			Offset: int64(binary.LittleEndian.Uint32(d[12:])),
+		}
+		if !icon {
+			e.XHotspot, e.YHotspot = int(binary.LittleEndian.Uint16(d[4:])), int(binary.LittleEndian.Uint16(d[6:]))
+		}
+		r.recoverEntry(i, e)
+	}
+	for off := int64(0); off < int64(len(b)); off++ {
+		if r.claimed(off) || !probe(b[off:]) {
+			continue
+		}
+		e := &Entry{Offset: off, Size: int64(len(b)) - off}
+		if noMask, err := r.decode(e); err == nil {
+			r.add(SeverityWarning, -1, off, "recovered an image not listed in the directory")
+			if noMask {
+				r.add(SeverityWarning, -1, off, "missing AND mask")
+			}
+			off = e.Offset + e.Size - 1
+		}
+	}
+	if len(r.mm) == 0 {
+		r.add(SeverityError, -1, 0, "no images recovered")
+	}
+	return r.entries, r.mm, r.issues
+}
+
+type recoverer struct {
+	validator
+	b       []byte
+	d       *Decoder
+	entries []*Entry
+	mm      []image.Image
+	ranges  [][2]int64
+}
+
+func (r *recoverer) recoverEntry(i int, e *Entry) {
+	size := int64(len(r.b))
+	if e.Offset >= size || !probe(r.b[e.Offset:]) {
+		found := false
+		for delta := int64(1); delta <= recoverWindow && !found; delta++ {
+			for _, off := range []int64{e.Offset - delta, e.Offset + delta} {
+				if off >= fileHeaderLen && off < size && probe(r.b[off:]) {
+					r.add(SeverityWarning, i, off, "image data found at "+strconv.FormatInt(off, 10)+" instead of "+strconv.FormatInt(e.Offset, 10))
+					e.Offset, found = off, true
+					break
+				}
+			}
+		}
+		if !found {
+			r.add(SeverityError, i, e.Offset, "no image data found at "+strconv.FormatInt(e.Offset, 10))
+			return
+		}
+	}
+	if r.claimed(e.Offset) {
+		r.add(SeverityWarning, i, e.Offset, "image data at "+strconv.FormatInt(e.Offset, 10)+" is already recovered")
+		return
+	}
+	if e.Offset+e.Size > size {
+		r.add(SeverityWarning, i, e.Offset, "data of "+strconv.FormatInt(e.Size, 10)+" bytes runs past the end of the file")
+		e.Size = size - e.Offset
+	}
+	noMask, err := r.decode(&Entry{Offset: e.Offset, Size: e.Size, XHotspot: e.XHotspot, YHotspot: e.YHotspot})
+	if err != nil && e.Size != size-e.Offset {
+		// The size may be wrong as well.
+		if noMask, err = r.decode(&Entry{Offset: e.Offset, Size: size - e.Offset, XHotspot: e.XHotspot, YHotspot: e.YHotspot}); err == nil {
+			r.add(SeverityWarning, i, e.Offset, "wrong data size: "+strconv.FormatInt(e.Size, 10))
+		}
+	}
+	if err != nil {
+		r.add(SeverityError, i, e.Offset, "invalid image data: "+err.Error())
+		return
+	}
+	if noMask {
+		r.add(SeverityWarning, i, e.Offset, "missing AND mask")
+	}
+}
+
+// decode decodes e found at e.Offset, which may take up to e.Size bytes,
+// and sets e.Size to the actual size on success.
+func (r *recoverer) decode(e *Entry) (noMask bool, err error) {
+	data := r.b[e.Offset : e.Offset+e.Size]
+	if err = r.d.decodeHeader(bytes.NewReader(data), e); err != nil {
+		return
+	}
+	if n := dataLen(data, e); n > 0 && n < e.Size {
+		e.Size = n
+	}
+	m, noMask, err := r.d.decode(e, true)
+	if err != nil {
+		return
+	}
+	r.entries = append(r.entries, e)
+	r.mm = append(r.mm, m)
+	r.ranges = append(r.ranges, [2]int64{e.Offset, e.Offset + e.Size})
+	return
+}
+
+func (r *recoverer) claimed(off int64) bool {
+	for _, rng := range r.ranges {
+		if off >= rng[0] && off < rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// probe reports whether b starts with a PNG image or a plausible BMP info header.
+func probe(b []byte) bool {
+	if bytes.HasPrefix(b, []byte(pngPrefix)) {
+		return true
+	}
+	if len(b) < bmpInfoHeaderLen {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(b) {
+	case bmpInfoHeaderLen, bmpV4InfoHeaderLen, bmpV5InfoHeaderLen:
+	default:
+		return false
+	}
+	width, height := int32(binary.LittleEndian.Uint32(b[4:])), int32(binary.LittleEndian.Uint32(b[8:]))
+	if height < 0 {
+		height = -height
+	}
+	if width < 1 || width > 1024 || height < 2 || height > 2048 || height%2 != 0 || binary.LittleEndian.Uint16(b[12:]) != 1 {
+		return false
+	}
+	switch binary.LittleEndian.Uint16(b[14:]) {
+	case 1, 2, 4, 8, 16, 24, 32:
+		return true
+	}
+	return false
+}
+
+// dataLen returns the length of the image data of e stored in b
+// or 0 if it's unknown.
+func dataLen(b []byte, e *Entry) int64 {
+	if e.PNG() {
+		for off := int64(len(pngPrefix)); off+8 <= int64(len(b)); {
+			n := int64(binary.BigEndian.Uint32(b[off:]))
+			typ := string(b[off+4 : off+8])
+			off += 8 + n + 4
+			if typ == "IEND" {
+				return off
+			}
+		}
+		return 0
+	}
+	infoLen := int64(binary.LittleEndian.Uint32(b))
+	compression := binary.LittleEndian.Uint32(b[16:])
+	n := infoLen + int64(e.Colors)*4
+	maskRowLen := (int64(e.Width) + 31) / 32 * 4
+	switch compression {
+	case biRGB, biBitFields:
+	case biRLE8, biRLE4:
+		return n + int64(binary.LittleEndian.Uint32(b[20:])) + maskRowLen*int64(e.Height)
+	default:
+		return 0
+	}
+	if infoLen == bmpInfoHeaderLen && compression == biBitFields {
+		n += 4 * 3
+	}
+	rowLen := (int64(e.Width)*int64(e.BPP) + 31) / 32 * 4
+	return n + (rowLen+maskRowLen)*int64(e.Height)
+}
@@ -0,0 +1,174 @@
+package icondir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota + 1
+	SeverityError
+)
+
+// Issue describes a problem found by Validate.
+// Entry is -1 for problems not related to a single entry.
+type Issue struct {
+	Severity Severity
+	Entry    int
+	Offset   int64
+	Message  string
+}
+
+type validator struct {
+	issues []Issue
+}
+
+func (v *validator) add(severity Severity, entry int, offset int64, msg string) {
+	v.issues = append(v.issues, Issue{
+		Severity: severity,
+		Entry:    entry,
+		Offset:   offset,
+		Message:  msg,
+	})
+}
+
+// Validate checks the ICO or CUR file b and reports all the problems found
+// instead of stopping at the first one.
+func Validate(b []byte, icon bool) []Issue {
+	v := &validator{}
+	if len(b) < fileHeaderLen {
+		v.add(SeverityError, -1, 0, "truncated file header")
+		return v.issues
+	}
+	if icon && string(b[:4]) != icoPrefix {
+		v.add(SeverityError, -1, 0, "not an ICO file")
+		return v.issues
+	}
+	if !icon && string(b[:4]) != curPrefix {
+		v.add(SeverityError, -1, 0, "not a CUR file")
+		return v.issues
+	}
+	count := int(binary.LittleEndian.Uint16(b[4:]))
+	if count == 0 {
+		v.add(SeverityError, -1, 4, "no entries")
+		return v.issues
+	}
+	dirLen := int64(fileHeaderLen + count*dirEntryLen)
+	if int64(len(b)) < dirLen {
+		v.add(SeverityError, -1, fileHeaderLen, "truncated directory: "+strconv.Itoa(count)+" entries declared")
+		return v.issues
+	}
+	type pair struct{ width, height, bpp int }
+	seen := map[pair]int{}
+	entries := make([]*Entry, count)
+	for i := range entries {
+		off := int64(fileHeaderLen + i*dirEntryLen)
+		d := b[off : off+dirEntryLen]
+		e := &Entry{
+			Width:  int(d[0]),
+			Height: int(d[1]),
+			Colors: int(d[2]),
+			Size:   int64(binary.LittleEndian.Uint32(d[8:])),
+			Offset: int64(binary.LittleEndian.Uint32(d[12:])),
+		}
+		if e.Width == 0 {
+			e.Width = 256
+		}
+		if e.Height == 0 {
+			e.Height = 256
+		}
+		if d[3] != 0 {
+			v.add(SeverityWarning, i, off+3, "non-zero reserved field: "+strconv.Itoa(int(d[3])))
+		}
+		if icon {
+			e.BPP = int(binary.LittleEndian.Uint16(d[6:]))
+			if planes := binary.LittleEndian.Uint16(d[4:]); planes > 1 {
+				v.add(SeverityWarning, i, off+4, "invalid color planes: "+strconv.Itoa(int(planes)))
+			}
+		} else {
+			e.XHotspot, e.YHotspot = int(binary.LittleEndian.Uint16(d[4:])), int(binary.LittleEndian.Uint16(d[6:]))
+		}
+		entries[i] = e
+		switch {
+		case e.Offset < dirLen:
+			v.add(SeverityError, i, off+12, "data at "+strconv.FormatInt(e.Offset, 10)+" points into the directory")
+			continue
+		case e.Offset+e.Size > int64(len(b)):
+			v.add(SeverityError, i, off+8, "data at "+strconv.FormatInt(e.Offset, 10)+" of "+strconv.FormatInt(e.Size, 10)+" bytes is out of bounds")
+			continue
+		}
+		v.validateData(i, off, e, b[e.Offset:e.Offset+e.Size])
+		if !icon && (e.XHotspot >= e.Width || e.YHotspot >= e.Height) {
+			v.add(SeverityError, i, off+4, "hotspot "+strconv.Itoa(e.XHotspot)+"x"+strconv.Itoa(e.YHotspot)+" is outside the "+strconv.Itoa(e.Width)+"x"+strconv.Itoa(e.Height)+" image")
+		}
+		k := pair{e.Width, e.Height, e.BPP}
+		if j, ok := seen[k]; ok {
+			v.add(SeverityWarning, i, off, "duplicates entry "+strconv.Itoa(j)+": "+strconv.Itoa(e.Width)+"x"+strconv.Itoa(e.Height)+", "+strconv.Itoa(e.BPP)+" bits per pixel")
+		} else {
+			seen[k] = i
+		}
+	}
+	order := make([]int, 0, count)
+	for i, e := range entries {
+		if e.Offset >= dirLen && e.Offset+e.Size <= int64(len(b)) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return entries[order[i]].Offset < entries[order[j]].Offset })
+	for k := 1; k < len(order); k++ {
+		prev, e := entries[order[k-1]], entries[order[k]]
+		if prev.Offset+prev.Size > e.Offset {
+			v.add(SeverityError, order[k], e.Offset, "data overlaps entry "+strconv.Itoa(order[k-1]))
+		}
+	}
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].Entry < v.issues[j].Entry })
+	return v.issues
+}
+
+// validateData compares the directory entry e at off with the image data b
+// and updates e with the actual values.
+func (v *validator) validateData(i int, off int64, e *Entry, b []byte) {
+	isPNG := bytes.HasPrefix(b, []byte(pngPrefix))
+	if !isPNG && len(b) >= bmpInfoHeaderLen {
+		if planes := binary.LittleEndian.Uint16(b[12:]); planes != 1 {
+			v.add(SeverityError, i, e.Offset+12, "invalid BMP color planes: "+strconv.Itoa(int(planes)))
+		}
+		if height := int32(binary.LittleEndian.Uint32(b[8:])); height%2 != 0 {
+			v.add(SeverityError, i, e.Offset+8, "odd BMP height: "+strconv.Itoa(int(height)))
+			return
+		}
+	}
+	actual := &Entry{Size: e.Size}
+	if err := decodeHeader(bytes.NewReader(b), actual); err != nil {
+		v.add(SeverityError, i, e.Offset, "invalid image data: "+err.Error())
+		return
+	}
+	dirWidth, dirHeight := actual.Width, actual.Height
+	if dirWidth > 256 {
+		dirWidth = 256
+	}
+	if dirHeight > 256 {
+		dirHeight = 256
+	}
+	if e.Width != dirWidth || e.Height != dirHeight {
+		v.add(SeverityWarning, i, off, "directory size "+strconv.Itoa(e.Width)+"x"+strconv.Itoa(e.Height)+" doesn't match image size "+strconv.Itoa(actual.Width)+"x"+strconv.Itoa(actual.Height))
+	}
+	colors := 0
+	if actual.BPP <= 8 && actual.Colors < 256 {
+		colors = actual.Colors
+	}
+	if e.Colors != colors {
+		v.add(SeverityWarning, i, off+2, "directory color count "+strconv.Itoa(e.Colors)+" doesn't match "+strconv.Itoa(colors))
+	}
+	if e.BPP != 0 && e.BPP != actual.BPP {
+		v.add(SeverityWarning, i, off+6, "directory bit depth "+strconv.Itoa(e.BPP)+" doesn't match image bit depth "+strconv.Itoa(actual.BPP))
+	}
+	if isPNG && actual.BPP != 32 {
+		v.add(SeverityWarning, i, e.Offset, strconv.Itoa(actual.BPP)+"-bit PNG image, 32-bit expected")
+	}
+	e.Width, e.Height, e.BPP = actual.Width, actual.Height, actual.BPP
+}
@@ -0,0 +1,128 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Dither is a dithering method used to map colors to a palette.
+type Dither int
+
+const (
+	None Dither = iota
+	FloydSteinberg
+	Atkinson
+	Bayer
+)
+
+type diffusion struct {
+	dx, dy int
+	weight float64
+}
+
+var diffusions = map[Dither][]diffusion{
+	FloydSteinberg: {
+		{1, 0, 7.0 / 16},
+		{-1, 1, 3.0 / 16},
+		{0, 1, 5.0 / 16},
+		{1, 1, 1.0 / 16},
+	},
+	Atkinson: {
+		{1, 0, 1.0 / 8},
+		{2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8},
+		{0, 1, 1.0 / 8},
+		{1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+}
+
+// diffuse maps the pixels of m to dst diffusing the quantization error
+// to the neighboring pixels. Transparent pixels neither receive nor
+// spread the error.
+func diffuse(dst *image.Paletted, m image.Image, kernel []diffusion) {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	pix := make([][3]float64, w*h)
+	opaque := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c, ok := opaqueAt(m, b.Min.X+x, b.Min.Y+y)
+			pix[y*w+x] = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+			opaque[y*w+x] = ok
+		}
+	}
+	palette := paletteValues(dst.Palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !opaque[y*w+x] {
+				continue
+			}
+			c := pix[y*w+x]
+			i := nearest(dst.Palette, clamp8(c[0]), clamp8(c[1]), clamp8(c[2]))
+			dst.Pix[dst.PixOffset(b.Min.X+x, b.Min.Y+y)] = uint8(i)
+			var e [3]float64
+			for j := range e {
+				e[j] = c[j] - palette[i][j]
+			}
+			for _, d := range kernel {
+				x2, y2 := x+d.dx, y+d.dy
+				if x2 < 0 || x2 >= w || y2 >= h || !opaque[y2*w+x2] {
+					continue
+				}
+				for j := range e {
+					pix[y2*w+x2][j] += e[j] * d.weight
+				}
+			}
+		}
+	}
+}
+
+var bayer8 = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// ordered maps the pixels of m to dst adding the 8x8 Bayer matrix threshold
+// scaled to the average distance between the palette colors.
+func ordered(dst *image.Paletted, m image.Image) {
+	b := m.Bounds()
+	spread := 0xFF / math.Cbrt(float64(len(dst.Palette)))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c, ok := opaqueAt(m, x, y)
+			if !ok {
+				continue
+			}
+			t := ((bayer8[(y-b.Min.Y)%8][(x-b.Min.X)%8]+0.5)/64 - 0.5) * spread
+			i := nearest(dst.Palette, clamp8(float64(c.R)+t), clamp8(float64(c.G)+t), clamp8(float64(c.B)+t))
+			dst.Pix[dst.PixOffset(x, y)] = uint8(i)
+		}
+	}
+}
+
+func paletteValues(p color.Palette) [][3]float64 {
+	values := make([][3]float64, len(p))
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		values[i] = [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+	}
+	return values
+}
+
+func clamp8(v float64) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xFF {
+		return 0xFF
+	}
+	return int(math.Round(v))
+}
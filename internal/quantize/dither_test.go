@@ -0,0 +1,66 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var blackWhite = color.Palette{color.Black, color.White}
+
+func TestMapDither(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range m.Pix {
+		m.Pix[i] = 0x80
+	}
+	tests := []struct {
+		name     string
+		dither   Dither
+		min, max int
+	}{
+		{"None", None, 1024, 1024},
+		{"FloydSteinberg", FloydSteinberg, 480, 544},
+		{"Atkinson", Atkinson, 400, 624},
+		{"Bayer", Bayer, 480, 544},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paletted := Map(m, blackWhite, test.dither)
+			white := 0
+			for _, i := range paletted.Pix {
+				white += int(i)
+			}
+			if white < test.min || white > test.max {
+				t.Errorf("white pixels = %d; want [%d, %d]", white, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestMapDitherTransparent(t *testing.T) {
+	m1 := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	m2 := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if (x+y)%3 == 0 {
+				// Only the color of transparent pixels differs.
+				m2.SetNRGBA(x, y, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF})
+				continue
+			}
+			c := color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: 0x80, A: 0xFF}
+			m1.SetNRGBA(x, y, c)
+			m2.SetNRGBA(x, y, c)
+		}
+	}
+	for _, d := range []Dither{FloydSteinberg, Atkinson, Bayer} {
+		p1, p2 := Map(m1, blackWhite, d), Map(m2, blackWhite, d)
+		for i := range p1.Pix {
+			if p1.Pix[i] != p2.Pix[i] {
+				t.Fatalf("Map(%d).Pix[%d] = %d; want %d", d, i, p2.Pix[i], p1.Pix[i])
+			}
+			if x, y := i%16, i/16; (x+y)%3 == 0 && p1.Pix[i] != 0 {
+				t.Fatalf("Map(%d).Pix[%d] = %d; want 0", d, i, p1.Pix[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package quantize implements color quantization used to produce paletted icons.
+//
+// Fully transparent pixels are masked by the icon AND mask, so they are
+// excluded from quantization and their colors are ignored.
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+type histEntry struct {
+	c     [3]uint8
+	count int
+}
+
+type box struct {
+	entries []histEntry
+	count   int
+}
+
+// axis returns the channel with the largest range of values in b and its range.
+func (b *box) axis() (axis, width int) {
+	for i := 0; i < 3; i++ {
+		min, max := 0xFF, 0
+		for _, e := range b.entries {
+			if int(e.c[i]) < min {
+				min = int(e.c[i])
+			}
+			if int(e.c[i]) > max {
+				max = int(e.c[i])
+			}
+		}
+		if max-min > width {
+			axis, width = i, max-min
+		}
+	}
+	return
+}
+
+func (b *box) mean() color.NRGBA {
+	var sum [3]int
+	for _, e := range b.entries {
+		for i := 0; i < 3; i++ {
+			sum[i] += int(e.c[i]) * e.count
+		}
+	}
+	c := color.NRGBA{A: 0xFF}
+	c.R = uint8((sum[0] + b.count/2) / b.count)
+	c.G = uint8((sum[1] + b.count/2) / b.count)
+	c.B = uint8((sum[2] + b.count/2) / b.count)
+	return c
+}
+
+// MedianCut returns a palette of at most n opaque colors representing the colors
+// of the non-transparent pixels of m using the median cut algorithm.
+// If there are no more than n such colors, they are returned as is.
+// The palette is empty if all the pixels are transparent.
+func MedianCut(m image.Image, n int) color.Palette {
+	hist := map[[3]uint8]int{}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c, ok := opaqueAt(m, x, y); ok {
+				hist[[3]uint8{c.R, c.G, c.B}]++
+			}
+		}
+	}
+	root := &box{}
+	for c, count := range hist {
+		root.entries = append(root.entries, histEntry{c: c, count: count})
+		root.count += count
+	}
+	// Make the result independent of the map iteration order.
+	sort.Slice(root.entries, func(i, j int) bool {
+		a, b := root.entries[i].c, root.entries[j].c
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	if len(root.entries) <= n {
+		p := make(color.Palette, len(root.entries))
+		for i, e := range root.entries {
+			p[i] = color.NRGBA{R: e.c[0], G: e.c[1], B: e.c[2], A: 0xFF}
+		}
+		return p
+	}
+	boxes := []*box{root}
+	for len(boxes) < n {
+		// Split the box with the widest channel range weighted by its population.
+		best, bestAxis, bestScore := -1, 0, 0
+		for i, b := range boxes {
+			if len(b.entries) < 2 {
+				continue
+			}
+			axis, width := b.axis()
+			if score := width * b.count; score > bestScore || best == -1 {
+				best, bestAxis, bestScore = i, axis, score
+			}
+		}
+		if best == -1 {
+			break
+		}
+		b := boxes[best]
+		sort.SliceStable(b.entries, func(i, j int) bool { return b.entries[i].c[bestAxis] < b.entries[j].c[bestAxis] })
+		// Split at the median pixel, keeping both halves non-empty.
+		i, count := 0, 0
+		for ; i < len(b.entries)-1; i++ {
+			count += b.entries[i].count
+			if 2*count >= b.count {
+				i++
+				break
+			}
+		}
+		left, right := &box{entries: b.entries[:i]}, &box{entries: b.entries[i:]}
+		for _, e := range left.entries {
+			left.count += e.count
+		}
+		right.count = b.count - left.count
+		boxes[best] = left
+		boxes = append(boxes, right)
+	}
+	p := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		p[i] = b.mean()
+	}
+	return p
+}
+
+// Map returns m converted to a paletted image with the palette p, mapping
+// every non-transparent pixel to the nearest color with the dithering method d.
+// Transparent pixels are mapped to the index 0.
+func Map(m image.Image, p color.Palette, d Dither) *image.Paletted {
+	b := m.Bounds()
+	dst := image.NewPaletted(b, p)
+	switch d {
+	case FloydSteinberg, Atkinson:
+		diffuse(dst, m, diffusions[d])
+		return dst
+	case Bayer:
+		ordered(dst, m)
+		return dst
+	}
+	cache := map[color.NRGBA]uint8{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c, ok := opaqueAt(m, x, y)
+			if !ok {
+				continue
+			}
+			i, ok := cache[c]
+			if !ok {
+				i = uint8(nearest(p, int(c.R), int(c.G), int(c.B)))
+				cache[c] = i
+			}
+			dst.Pix[dst.PixOffset(x, y)] = i
+		}
+	}
+	return dst
+}
+
+// nearest returns the index of the color in p closest to r, g, b.
+func nearest(p color.Palette, r, g, b int) int {
+	best, bestDist := 0, -1
+	for i, c := range p {
+		pr, pg, pb, _ := c.RGBA()
+		dr, dg, db := r-int(pr>>8), g-int(pg>>8), b-int(pb>>8)
+		if dist := dr*dr + dg*dg + db*db; bestDist == -1 || dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
+
+// opaqueAt returns the non-premultiplied color of the pixel at x, y of m
+// with the alpha ignored and reports whether the pixel isn't fully transparent.
+func opaqueAt(m image.Image, x, y int) (color.NRGBA, bool) {
+	c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+	if c.A == 0 {
+		return color.NRGBA{}, false
+	}
+	c.A = 0xFF
+	return c, true
+}
@@ -0,0 +1,74 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMedianCutFewColors(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	m.SetNRGBA(0, 0, color.NRGBA{R: 0xFF, A: 0xFF})
+	m.SetNRGBA(1, 0, color.NRGBA{G: 0xFF, A: 0xFF})
+	m.SetNRGBA(2, 0, color.NRGBA{G: 0xFF, A: 0x80})
+	m.SetNRGBA(3, 0, color.NRGBA{B: 0xFF})
+	p := MedianCut(m, 16)
+	expected := color.Palette{
+		color.NRGBA{G: 0xFF, A: 0xFF},
+		color.NRGBA{R: 0xFF, A: 0xFF},
+	}
+	if len(p) != len(expected) {
+		t.Fatalf("MedianCut() = %v; want %v", p, expected)
+	}
+	for i := range p {
+		if p[i] != expected[i] {
+			t.Errorf("MedianCut()[%d] = %v; want %v", i, p[i], expected[i])
+		}
+	}
+}
+
+func TestMedianCut(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	for _, n := range []int{2, 16, 256} {
+		p := MedianCut(m, n)
+		if len(p) != n {
+			t.Fatalf("len(MedianCut(%d)) = %d; want %d", n, len(p), n)
+		}
+		// The gradient is uniform, so every color should be close to its nearest palette entry.
+		maxDist := 0
+		for y := 0; y < 256; y += 5 {
+			for x := 0; x < 256; x += 5 {
+				r, g, b, _ := p[nearest(p, x, y, 0x80)].RGBA()
+				dr, dg, db := x-int(r>>8), y-int(g>>8), 0x80-int(b>>8)
+				if dist := dr*dr + dg*dg + db*db; dist > maxDist {
+					maxDist = dist
+				}
+			}
+		}
+		if limit := 2 * (256 * 256 / n); maxDist > limit {
+			t.Errorf("max distance for %d colors = %d; want <= %d", n, maxDist, limit)
+		}
+	}
+}
+
+func TestMedianCutTransparent(t *testing.T) {
+	if p := MedianCut(image.NewNRGBA(image.Rect(0, 0, 4, 4)), 16); len(p) != 0 {
+		t.Errorf("MedianCut() = %v; want []", p)
+	}
+}
+
+func TestMap(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	m.SetNRGBA(0, 0, color.NRGBA{R: 0xF0, A: 0xFF})
+	m.SetNRGBA(1, 0, color.NRGBA{G: 0x10, B: 0xE0, A: 0x40})
+	p := color.Palette{color.Black, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{B: 0xFF, A: 0xFF}}
+	paletted := Map(m, p, None)
+	if expected := []uint8{1, 2, 0}; string(paletted.Pix) != string(expected) {
+		t.Errorf("Map().Pix = %v; want %v", paletted.Pix, expected)
+	}
+}
@@ -0,0 +1,80 @@
+package resample
+
+import "math"
+
+// Filter is a resampling filter defined by its kernel.
+type Filter struct {
+	// Support is the kernel radius in source pixels when upsampling.
+	Support float64
+
+	// Kernel returns the weight of a pixel at the distance x.
+	Kernel func(x float64) float64
+}
+
+var (
+	// Box is the box (nearest-neighbor when upsampling, area-averaging when downsampling) filter.
+	Box = Filter{
+		Support: 0.5,
+		Kernel: func(x float64) float64 {
+			if x >= -0.5 && x < 0.5 {
+				return 1
+			}
+			return 0
+		},
+	}
+
+	// Bilinear is the triangle (tent) filter.
+	Bilinear = Filter{
+		Support: 1,
+		Kernel: func(x float64) float64 {
+			x = math.Abs(x)
+			if x < 1 {
+				return 1 - x
+			}
+			return 0
+		},
+	}
+
+	// CatmullRom is the Catmull-Rom cubic filter (B=0, C=0.5).
+	CatmullRom = Filter{
+		Support: 2,
+		Kernel:  bicubic(0, 0.5),
+	}
+
+	// Mitchell is the Mitchell-Netravali cubic filter (B=1/3, C=1/3).
+	Mitchell = Filter{
+		Support: 2,
+		Kernel:  bicubic(1.0/3, 1.0/3),
+	}
+
+	// Lanczos3 is the Lanczos filter with 3 lobes.
+	Lanczos3 = Filter{
+		Support: 3,
+		Kernel: func(x float64) float64 {
+			x = math.Abs(x)
+			if x == 0 {
+				return 1
+			}
+			if x >= 3 {
+				return 0
+			}
+			x *= math.Pi
+			return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+		},
+	}
+)
+
+// bicubic returns the kernel of the Mitchell-Netravali family of cubic filters.
+func bicubic(b, c float64) func(x float64) float64 {
+	return func(x float64) float64 {
+		x = math.Abs(x)
+		switch {
+		case x < 1:
+			return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+		case x < 2:
+			return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+		default:
+			return 0
+		}
+	}
+}
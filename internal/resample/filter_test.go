@@ -0,0 +1,42 @@
+package resample
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    Filter
+		center    float64
+		tolerance float64
+	}{
+		{"Box", Box, 1, 1e-9},
+		{"Bilinear", Bilinear, 1, 1e-9},
+		{"CatmullRom", CatmullRom, 1, 1e-9},
+		{"Mitchell", Mitchell, 8.0 / 9, 1e-9},
+		{"Lanczos3", Lanczos3, 1, 0.01},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.filter.Kernel(0); math.Abs(actual-test.center) > 1e-9 {
+				t.Errorf("Kernel(0) = %v; want %v", actual, test.center)
+			}
+			if actual := test.filter.Kernel(test.filter.Support + 0.01); actual != 0 {
+				t.Errorf("Kernel(%v) = %v; want 0", test.filter.Support+0.01, actual)
+			}
+			// The weights of the pixels at the integer distances from
+			// any point should sum to (almost) 1.
+			for _, offset := range []float64{0, 0.25, 0.5, 0.75} {
+				sum := 0.0
+				for i := -4; i <= 4; i++ {
+					sum += test.filter.Kernel(float64(i) + offset)
+				}
+				if math.Abs(sum-1) > test.tolerance {
+					t.Errorf("sum of weights at offset %v = %v; want 1", offset, sum)
+				}
+			}
+		})
+	}
+}
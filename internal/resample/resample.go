@@ -0,0 +1,205 @@
+// Package resample implements image resampling used to produce icons of different sizes.
+//
+// Images are resampled in premultiplied linear light, so translucent edges
+// don't get dark halos and blending of colors is perceptually correct.
+package resample
+
+import (
+	"image"
+	"math"
+)
+
+// Resize returns m resampled to width x height pixels with the filter f.
+func Resize(m image.Image, width, height int, f Filter) *image.NRGBA {
+	b := m.Bounds()
+	src := load(m)
+	// Resample rows, then columns of the transposed result.
+	tmp := resize(src, b.Dx(), b.Dy(), width, f)
+	return store(resize(tmp, b.Dy(), width, height, f), width, height)
+}
+
+// resize resamples n consecutive lines of src with srcLen pixels each to dstLen pixels.
+// The result is transposed: each line becomes a column in the output.
+func resize(src []float64, srcLen, n, dstLen int, f Filter) []float64 {
+	dst := make([]float64, 4*dstLen*n)
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := math.Max(scale, 1)
+	support := f.Support * filterScale
+	weights := make([]float64, 0, int(math.Ceil(2*support))+1)
+	for x := 0; x < dstLen; x++ {
+		center := (float64(x)+0.5)*scale - 0.5
+		left, right := int(math.Ceil(center-support)), int(math.Floor(center+support))
+		weights = weights[:0]
+		sum := 0.0
+		for i := left; i <= right; i++ {
+			w := f.Kernel((float64(i) - center) / filterScale)
+			weights = append(weights, w)
+			sum += w
+		}
+		if sum == 0 {
+			// The kernel is too narrow to cover any pixel, fall back to the nearest one.
+			left, weights, sum = int(math.Round(center)), append(weights[:0], 1), 1
+		}
+		for line := 0; line < n; line++ {
+			var r, g, b, a float64
+			for j, w := range weights {
+				if w == 0 {
+					continue
+				}
+				i := left + j
+				if i < 0 {
+					i = 0
+				} else if i >= srcLen {
+					i = srcLen - 1
+				}
+				p := src[4*(line*srcLen+i):]
+				r += p[0] * w
+				g += p[1] * w
+				b += p[2] * w
+				a += p[3] * w
+			}
+			p := dst[4*(x*n+line):]
+			p[0], p[1], p[2], p[3] = r/sum, g/sum, b/sum, a/sum
+		}
+	}
+	return dst
+}
+
+// load returns the pixels of m as premultiplied linear RGBA values in [0, 1].
+func load(m image.Image) []float64 {
+	b := m.Bounds()
+	pix := make([]float64, 0, 4*b.Dx()*b.Dy())
+	add := func(r, g, b, a uint8) {
+		if a == 0 {
+			pix = append(pix, 0, 0, 0, 0)
+			return
+		}
+		alpha := float64(a) / 0xFF
+		pix = append(pix, toLinear8[r]*alpha, toLinear8[g]*alpha, toLinear8[b]*alpha, alpha)
+	}
+	switch m := m.(type) {
+	case *image.NRGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			p := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(p); i += 4 {
+				add(p[i+0], p[i+1], p[i+2], p[i+3])
+			}
+		}
+	case *image.RGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			p := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(p); i += 4 {
+				a := p[i+3]
+				if a == 0 || a == 0xFF {
+					add(p[i+0], p[i+1], p[i+2], a)
+					continue
+				}
+				add(unpremultiply(p[i+0], a), unpremultiply(p[i+1], a), unpremultiply(p[i+2], a), a)
+			}
+		}
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, c := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+				add(c, c, c, 0xFF)
+			}
+		}
+	case *image.Paletted:
+		palette := make([][4]uint8, len(m.Palette))
+		for i, c := range m.Palette {
+			r, g, b, a := c.RGBA()
+			if a != 0 && a != 0xFFFF {
+				r, g, b = r*0xFFFF/a, g*0xFFFF/a, b*0xFFFF/a
+			}
+			palette[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, i := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+				var c [4]uint8
+				if int(i) < len(palette) {
+					c = palette[i]
+				}
+				add(c[0], c[1], c[2], c[3])
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, b, a := m.At(x, y).RGBA()
+				if a == 0 {
+					pix = append(pix, 0, 0, 0, 0)
+					continue
+				}
+				alpha := float64(a) / 0xFFFF
+				pix = append(pix,
+					toLinear(float64(r)/float64(a))*alpha,
+					toLinear(float64(g)/float64(a))*alpha,
+					toLinear(float64(b)/float64(a))*alpha,
+					alpha,
+				)
+			}
+		}
+	}
+	return pix
+}
+
+// store returns the premultiplied linear RGBA values of pix as a width x height image.
+func store(pix []float64, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(pix); i += 4 {
+		a := clamp(pix[i+3])
+		if a == 0 {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			dst.Pix[i+j] = to8(fromLinear(clamp(pix[i+j] / a)))
+		}
+		dst.Pix[i+3] = to8(a)
+	}
+	return dst
+}
+
+var toLinear8 [256]float64
+
+func init() {
+	for i := range toLinear8 {
+		toLinear8[i] = toLinear(float64(i) / 0xFF)
+	}
+}
+
+// toLinear converts the sRGB-encoded value v to linear light.
+func toLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// fromLinear converts the linear light value v to sRGB encoding.
+func fromLinear(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func unpremultiply(c, a uint8) uint8 {
+	v := (uint32(c)*0xFF + uint32(a)/2) / uint32(a)
+	if v > 0xFF {
+		v = 0xFF
+	}
+	return uint8(v)
+}
+
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func to8(v float64) uint8 {
+	return uint8(math.Round(v * 0xFF))
+}
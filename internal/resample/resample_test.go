@@ -0,0 +1,107 @@
+package resample
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+var filters = []struct {
+	name   string
+	filter Filter
+}{
+	{"Box", Box},
+	{"Bilinear", Bilinear},
+	{"CatmullRom", CatmullRom},
+	{"Mitchell", Mitchell},
+	{"Lanczos3", Lanczos3},
+}
+
+func TestResizeIdentity(t *testing.T) {
+	m := testutil.Icon.Entries[12].MustDecode()
+	for _, f := range []Filter{Box, Bilinear, CatmullRom, Lanczos3} {
+		testutil.Compare(t, m, Resize(m, 64, 64, f))
+	}
+}
+
+func TestResizeUniform(t *testing.T) {
+	c := color.NRGBA{R: 0x40, G: 0x80, B: 0xC0, A: 0xFF}
+	m := image.NewNRGBA(image.Rect(0, 0, 100, 60))
+	draw.Draw(m, m.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	for _, f := range filters {
+		t.Run(f.name, func(t *testing.T) {
+			for _, size := range []image.Point{{16, 16}, {33, 7}, {200, 120}} {
+				m2 := Resize(m, size.X, size.Y, f.filter)
+				if !m2.Bounds().Eq(image.Rectangle{Max: size}) {
+					t.Fatalf("Bounds() = %s; want %s", m2.Bounds(), image.Rectangle{Max: size})
+				}
+				for y := 0; y < size.Y; y++ {
+					for x := 0; x < size.X; x++ {
+						if actual := m2.NRGBAAt(x, y); actual != c {
+							t.Fatalf("NRGBAAt(%d, %d) = %v; want %v", x, y, actual, c)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResizeNoHalo(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(m, image.Rect(32, 0, 64, 64), image.White, image.Point{}, draw.Src)
+	for _, f := range filters {
+		t.Run(f.name, func(t *testing.T) {
+			m2 := Resize(m, 13, 13, f.filter)
+			for x := 0; x < 13; x++ {
+				if c := m2.NRGBAAt(x, 6); c.A != 0 && (c.R != 0xFF || c.G != 0xFF || c.B != 0xFF) {
+					t.Errorf("NRGBAAt(%d, 6) = %v; want white", x, c)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeLinearLight(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 2, 1))
+	m.Pix[1] = 0xFF
+	// The average of black and white in linear light is 0.5, which is 188 in sRGB.
+	if actual, expected := Resize(m, 1, 1, Box).NRGBAAt(0, 0), (color.NRGBA{R: 188, G: 188, B: 188, A: 0xFF}); actual != expected {
+		t.Errorf("NRGBAAt(0, 0) = %v; want %v", actual, expected)
+	}
+}
+
+type genericImage struct {
+	image.Image
+}
+
+func TestResizeImageTypes(t *testing.T) {
+	src := testutil.Icon.Entries[12].MustDecode()
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+	gray := image.NewGray(src.Bounds())
+	draw.Draw(gray, gray.Bounds(), src, src.Bounds().Min, draw.Src)
+	tests := []struct {
+		name string
+		m    image.Image
+	}{
+		{"NRGBA", src},
+		{"RGBA", rgba},
+		{"Gray", gray},
+		{"Paletted", testutil.Palettize(testutil.Icon.Entries[6].MustDecode())},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := Resize(genericImage{test.m}, 20, 20, CatmullRom)
+			actual := Resize(test.m, 20, 20, CatmullRom)
+			for i := range expected.Pix {
+				if d := int(expected.Pix[i]) - int(actual.Pix[i]); d < -1 || d > 1 {
+					t.Fatalf("Pix[%d] = %d; want %d", i, actual.Pix[i], expected.Pix[i])
+				}
+			}
+		})
+	}
+}
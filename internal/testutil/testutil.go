@@ -129,10 +129,12 @@ func newIconDir(name string, entries []entry) IconDir {
 	var ee []entry
 	for _, e := range entries {
 		ee = append(ee, entry{
-			prefix: strings.TrimSuffix(name, filepath.Ext(name)),
-			Width:  e.Width,
-			Height: e.Height,
-			BPP:    e.BPP,
+			prefix:   strings.TrimSuffix(name, filepath.Ext(name)),
+			Width:    e.Width,
+			Height:   e.Height,
+			BPP:      e.BPP,
+			XHotspot: e.XHotspot,
+			YHotspot: e.YHotspot,
 		})
 	}
 	return IconDir{
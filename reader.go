@@ -2,77 +2,354 @@
 package ico
 
 import (
+	"errors"
 	"image"
 	"io"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/sergeymakinen/go-ico/internal/icondir"
 )
 
+// Errors matched by errors.Is against the errors of the package. They are shared
+// by packages ico and cur, and match the errors of the underlying PNG and BMP
+// decoders wrapped by EntryError as well.
+var (
+	ErrFormat      = icondir.ErrFormat
+	ErrUnsupported = icondir.ErrUnsupported
+	ErrLimit       = icondir.ErrLimit
+)
+
+// ErrIndex is returned by the Decoder and Editor methods given an icon index
+// out of range, which is a misuse rather than an invalid input.
+var ErrIndex = errors.New("ico: icon index out of range")
+
 // FormatError reports that the input is not a valid ICO.
 type FormatError string
 
 func (e FormatError) Error() string { return "ico: invalid format: " + string(e) }
 
+// Is reports whether target is ErrFormat.
+func (e FormatError) Is(target error) bool { return target == ErrFormat }
+
 // UnsupportedError reports that the input uses a valid but unimplemented ICO feature.
 type UnsupportedError string
 
 func (e UnsupportedError) Error() string { return "ico: unsupported feature: " + string(e) }
 
-// DecodeAll reads an ICO image from r and returns the stored icons.
-func DecodeAll(r io.Reader) ([]image.Image, error) {
-	d := icondir.NewDecoder(r, true)
+// Is reports whether target is ErrUnsupported.
+func (e UnsupportedError) Is(target error) bool { return target == ErrUnsupported }
+
+// LimitError reports that the input exceeds one of the DecodeOptions limits.
+type LimitError string
+
+func (e LimitError) Error() string { return "ico: limit exceeded: " + string(e) }
+
+// Is reports whether target is ErrLimit.
+func (e LimitError) Is(target error) bool { return target == ErrLimit }
+
+// EntryError reports an error related to a single icon.
+type EntryError struct {
+	// Entry is the index of the icon in the directory.
+	Entry int
+
+	// Offset is the position of the directory entry or the icon data
+	// in the ICO file the error is related to.
+	Offset int64
+
+	// Err is the underlying error, such as FormatError
+	// or an error of the image/png package.
+	Err error
+}
+
+func (e *EntryError) Error() string {
+	return "ico: icon " + strconv.Itoa(e.Entry) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + strings.TrimPrefix(e.Err.Error(), "ico: ")
+}
+
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Is reports whether target is the sentinel error matching
+// the underlying PNG or BMP decoder error.
+func (e *EntryError) Is(target error) bool { return target != nil && target == icondir.Kind(e.Err) }
+
+// Entry describes an icon stored in an ICO file.
+type Entry struct {
+	Width, Height int
+
+	// Colors is the number of colors in the icon palette or 0 if there is no palette.
+	Colors int
+
+	// BPP is the number of bits per pixel.
+	BPP int
+
+	// Offset and Size are the location and size of the icon data in the ICO file.
+	Offset, Size int64
+
+	// PNG reports whether the icon is stored as a PNG image rather than a BMP one.
+	PNG bool
+}
+
+func newEntry(e *icondir.Entry) Entry {
+	return Entry{
+		Width:  e.Width,
+		Height: e.Height,
+		Colors: e.Colors,
+		BPP:    e.BPP,
+		Offset: e.Offset,
+		Size:   e.Size,
+		PNG:    e.PNG(),
+	}
+}
+
+// DecodeOptions are the decoding parameters.
+type DecodeOptions struct {
+	// Keep, if not nil, selects the icons to decode. It's called for every
+	// directory entry before any icon data is read, with only the directory
+	// fields of e set: Width, Height, Colors, BPP, Offset and Size.
+	// The directory values may differ from the actual ones, BPP may be 0.
+	// Rejected icons are left out of the Decoder and, if r doesn't implement
+	// io.Seeker, skipped without being read into memory.
+	Keep func(e Entry) bool
+
+	// MaxEntries, if positive, limits the number of directory entries.
+	MaxEntries int
+
+	// MaxEntrySize, if positive, limits the data size of a single selected icon in bytes.
+	MaxEntrySize int64
+
+	// MaxTotalSize, if positive, limits the total data size of the selected icons in bytes.
+	MaxTotalSize int64
+
+	// MaxWidth and MaxHeight, if positive, limit the dimensions of the selected icons
+	// as declared in their BMP or PNG headers.
+	MaxWidth, MaxHeight int
+
+	// MaskFirst makes the AND mask alone define the transparency of 32 bit-per-pixel
+	// BMP icons and their alpha channel ignored. By default, the alpha channel is used
+	// unless it's zero for every pixel, as left by many old editors relying on the mask.
+	MaskFirst bool
+}
+
+func (o *DecodeOptions) apply(d *icondir.Decoder) *icondir.Decoder {
+	if o == nil {
+		return d
+	}
+	if o.Keep != nil {
+		d.Keep = func(e *icondir.Entry) bool {
+			e2 := newEntry(e)
+			e2.PNG = false
+			return o.Keep(e2)
+		}
+	}
+	d.Limits = icondir.Limits{
+		Entries:   o.MaxEntries,
+		EntrySize: o.MaxEntrySize,
+		TotalSize: o.MaxTotalSize,
+		Width:     o.MaxWidth,
+		Height:    o.MaxHeight,
+	}
+	d.MaskFirst = o.MaskFirst
+	return d
+}
+
+// Decoder reads the ICO directory once and decodes the stored icons on demand.
+type Decoder struct {
+	d       *icondir.Decoder
+	entries []Entry
+}
+
+// NewDecoder reads the ICO directory from r and returns a Decoder for the stored icons.
+// If r doesn't implement io.Seeker, the data of all icons selected by o.Keep
+// is read into memory. If o is nil, default parameters are used.
+// The returned Decoder is not safe for concurrent use.
+func NewDecoder(r io.Reader, o *DecodeOptions) (*Decoder, error) {
+	return newDecoder(o.apply(icondir.NewDecoder(r, true)))
+}
+
+// NewDecoderAt reads the ICO directory from r, which holds size bytes, and returns
+// a Decoder for the stored icons. Every icon is read through its own io.SectionReader,
+// so the returned Decoder is safe for concurrent use by multiple goroutines.
+// If o is nil, default parameters are used.
+func NewDecoderAt(r io.ReaderAt, size int64, o *DecodeOptions) (*Decoder, error) {
+	return newDecoder(o.apply(icondir.NewDecoderAt(r, size, true)))
+}
+
+func newDecoder(d *icondir.Decoder) (*Decoder, error) {
 	if err := d.DecodeDir(); err != nil {
 		return nil, convertErr(err)
 	}
-	_, mm, err := d.DecodeAll()
+	var entries []Entry
+	for _, e := range d.Entries() {
+		entries = append(entries, newEntry(e))
+	}
+	return &Decoder{
+		d:       d,
+		entries: entries,
+	}, nil
+}
+
+// Entries returns the descriptions of the stored icons in the directory order.
+func (d *Decoder) Entries() []Entry {
+	return append([]Entry{}, d.entries...)
+}
+
+// Best returns the index of the largest stored icon.
+func (d *Decoder) Best() int {
+	e, _ := d.d.Best()
+	return d.index(e)
+}
+
+// Match returns the index of the stored icon Windows would choose to display
+// at width x height pixels on a bpp bits-per-pixel display, the way the
+// LookupIconIdFromDirectoryEx function does.
+// The size is multiplied by scale, the DPI scale factor of the display
+// (for example, 1.5 for 150%), so 16x16 at 1.25 matches 20x20.
+// A non-positive scale is treated as 1.
+func (d *Decoder) Match(width, height, bpp int, scale float64) int {
+	if scale <= 0 {
+		scale = 1
+	}
+	return d.index(d.d.Match(int(math.Round(float64(width)*scale)), int(math.Round(float64(height)*scale)), bpp))
+}
+
+// Decode decodes the i-th stored icon.
+func (d *Decoder) Decode(i int) (image.Image, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	m, err := d.d.Decode(e)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return m, nil
+}
+
+// DecodeMask decodes the i-th stored icon into the color (XOR) image and
+// the 1-bit AND mask, which Decode combines into a single image.
+// The mask pixels are white where the screen is made transparent
+// or, under white color pixels, inverted, and black elsewhere.
+// The mask is nil for icons stored as PNG images.
+func (d *Decoder) DecodeMask(i int) (image.Image, *image.Paletted, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, mask, err := d.d.DecodeMask(e)
+	if err != nil {
+		return nil, nil, convertErr(err)
+	}
+	return m, mask, nil
+}
+
+// DecodeConfig returns the color model and dimensions of the i-th stored icon
+// without decoding the entire icon.
+func (d *Decoder) DecodeConfig(i int) (image.Config, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return image.Config{}, err
+	}
+	config, err := d.d.DecodeConfig(e)
+	if err != nil {
+		return image.Config{}, convertErr(err)
+	}
+	return config, nil
+}
+
+// ReadRaw returns the BMP or PNG data of the i-th stored icon exactly as it is
+// stored in the ICO file. It can be written back unchanged with Encoder.AddRaw.
+func (d *Decoder) ReadRaw(i int) ([]byte, error) {
+	e, err := d.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.d.ReadRaw(e)
 	if err != nil {
 		return nil, convertErr(err)
 	}
+	return b, nil
+}
+
+// DecodeAll reads an ICO image from r and returns the stored icons.
+func DecodeAll(r io.Reader) ([]image.Image, error) {
+	d, err := NewDecoder(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	var mm []image.Image
+	for i := range d.entries {
+		m, err := d.Decode(i)
+		if err != nil {
+			return nil, err
+		}
+		mm = append(mm, m)
+	}
 	return mm, nil
 }
 
 // Decode reads an ICO image from r and returns the largest stored icon
 // as an image.Image.
 func Decode(r io.Reader) (image.Image, error) {
-	d := icondir.NewDecoder(r, true)
-	if err := d.DecodeDir(); err != nil {
-		return nil, convertErr(err)
-	}
-	e, err := d.Best()
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return nil, convertErr(err)
+		return nil, err
 	}
-	m, err := d.Decode(e)
-	if err != nil {
-		return nil, convertErr(err)
-	}
-	return m, nil
+	return d.Decode(d.Best())
 }
 
 // DecodeConfig returns the color model and dimensions of the largest icon
 // stored in an ICO image without decoding the entire icon.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	d := icondir.NewDecoder(r, true)
-	if err := d.DecodeDir(); err != nil {
-		return image.Config{}, convertErr(err)
-	}
-	m, err := d.Best()
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return image.Config{}, convertErr(err)
+		return image.Config{}, err
 	}
-	config, err := d.DecodeConfig(m)
+	return d.DecodeConfig(d.Best())
+}
+
+// DecodeSize reads an ICO image from r and returns the stored icon
+// that fits the size and color depth best as an image.Image.
+// See Decoder.Match for the description of the arguments.
+func DecodeSize(r io.Reader, width, height, bpp int, scale float64) (image.Image, error) {
+	d, err := NewDecoder(r, nil)
 	if err != nil {
-		return image.Config{}, convertErr(err)
+		return nil, err
 	}
-	return config, nil
+	return d.Decode(d.Match(width, height, bpp, scale))
+}
+
+func (d *Decoder) entry(i int) (*icondir.Entry, error) {
+	entries := d.d.Entries()
+	if i < 0 || i >= len(entries) {
+		return nil, ErrIndex
+	}
+	return entries[i], nil
+}
+
+func (d *Decoder) index(e *icondir.Entry) int {
+	for i, e2 := range d.d.Entries() {
+		if e2 == e {
+			return i
+		}
+	}
+	return 0
 }
 
 func convertErr(err error) error {
-	switch err.(type) {
+	switch err := err.(type) {
+	case *icondir.EntryError:
+		return &EntryError{
+			Entry:  err.Entry,
+			Offset: err.Offset,
+			Err:    convertErr(err.Err),
+		}
 	case icondir.FormatError:
 		return FormatError(err.Error())
 	case icondir.UnsupportedError:
 		return UnsupportedError(err.Error())
+	case icondir.LimitError:
+		return LimitError(err.Error())
 	default:
 		return err
 	}
@@ -2,8 +2,15 @@ package ico
 
 import (
 	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
 	"testing"
 
+	"github.com/sergeymakinen/go-ico/cur"
 	"github.com/sergeymakinen/go-ico/internal/testutil"
 )
 
@@ -31,6 +38,20 @@ func TestDecodeShouldFail(t *testing.T) {
 	if expected := "ico: invalid format: not an ICO file"; err == nil || err.Error() != expected {
 		t.Fatalf("Decode() = _, %v; want %s", err, expected)
 	}
+	b = testutil.Icon.MustRead()
+	for n, expected := range map[int]string{
+		4:     "ico: invalid format: truncated file header",
+		30:    "ico: invalid format: truncated directory",
+		31422: "ico: icon 11 at offset 31422: invalid format: truncated image data",
+	} {
+		_, err = DecodeAll(bytes.NewReader(b[:n]))
+		if err == nil || err.Error() != expected {
+			t.Errorf("DecodeAll() = _, %v; want %s", err, expected)
+		}
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("errors.Is(%v, %v) = false; want true", err, ErrFormat)
+		}
+	}
 }
 
 func TestDecodeConfig(t *testing.T) {
@@ -54,3 +75,312 @@ func TestDecodeConfigShouldFail(t *testing.T) {
 		t.Fatalf("DecodeConfig() = _, %v; want %s", err, expected)
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{
+			name: "seekable",
+			r:    bytes.NewReader(b),
+		},
+		{
+			name: "non-seekable",
+			r:    struct{ io.Reader }{bytes.NewReader(b)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := NewDecoder(test.r, nil)
+			if err != nil {
+				t.Fatalf("NewDecoder() = _, %v; want nil", err)
+			}
+			entries := d.Entries()
+			if actual, expected := len(entries), len(testutil.Icon.Entries); actual != expected {
+				t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+			}
+			for i, e := range testutil.Icon.Entries {
+				if entries[i].Width != e.Width || entries[i].Height != e.Height || entries[i].BPP != e.BPP {
+					t.Errorf("Decoder.Entries()[%d] = %dx%d-%d; want %dx%d-%d", i, entries[i].Width, entries[i].Height, entries[i].BPP, e.Width, e.Height, e.BPP)
+				}
+			}
+			if expected := (Entry{Width: 64, Height: 64, Colors: 2, BPP: 1, Offset: 0xF6, Size: 0x430}); entries[0] != expected {
+				t.Errorf("Decoder.Entries()[0] = %+v; want %+v", entries[0], expected)
+			}
+			if !entries[11].PNG {
+				t.Errorf("Decoder.Entries()[11].PNG = false; want true")
+			}
+			for _, i := range []int{14, 3} {
+				m, err := d.Decode(i)
+				if err != nil {
+					t.Fatalf("Decoder.Decode(%d) = _, %v; want nil", i, err)
+				}
+				testutil.Compare(t, testutil.Icon.Entries[i].MustDecode(), m)
+			}
+			config, err := d.DecodeConfig(11)
+			if err != nil {
+				t.Fatalf("Decoder.DecodeConfig() = _, %v; want nil", err)
+			}
+			if expected := 256; config.Width != expected {
+				t.Errorf("image.Config.Width = %d; want %d", config.Width, expected)
+			}
+			if expected := 11; d.Best() != expected {
+				t.Errorf("Decoder.Best() = %d; want %d", d.Best(), expected)
+			}
+		})
+	}
+}
+
+func TestDecoderKeep(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	var seen []Entry
+	d, err := NewDecoder(struct{ io.Reader }{bytes.NewReader(b)}, &DecodeOptions{
+		Keep: func(e Entry) bool {
+			seen = append(seen, e)
+			return e.Width == 16 && e.BPP == 32
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	if actual, expected := len(seen), len(testutil.Icon.Entries); actual != expected {
+		t.Fatalf("DecodeOptions.Keep called %d times; want %d", actual, expected)
+	}
+	if expected := (Entry{Width: 256, Height: 256, BPP: 32, Offset: seen[11].Offset, Size: seen[11].Size}); seen[11] != expected {
+		t.Errorf("DecodeOptions.Keep(%+v); want %+v", seen[11], expected)
+	}
+	entries := d.Entries()
+	if actual, expected := len(entries), 1; actual != expected {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+	}
+	m, err := d.Decode(0)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, testutil.Icon.Entries[14].MustDecode(), m)
+}
+
+func TestDecoderKeepShouldFail(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	_, err := NewDecoder(bytes.NewReader(b), &DecodeOptions{
+		Keep: func(e Entry) bool { return false },
+	})
+	if expected := "ico: invalid format: no matching icons"; err == nil || err.Error() != expected {
+		t.Fatalf("NewDecoder() = _, %v; want %s", err, expected)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	tests := []struct {
+		name     string
+		r        io.Reader
+		o        *DecodeOptions
+		expected string
+	}{
+		{
+			name:     "entries",
+			r:        bytes.NewReader(b),
+			o:        &DecodeOptions{MaxEntries: 10},
+			expected: "ico: limit exceeded: too many entries: 15",
+		},
+		{
+			name:     "entry size",
+			r:        bytes.NewReader(b),
+			o:        &DecodeOptions{MaxEntrySize: 1024},
+			expected: "ico: icon 0 at offset 6: limit exceeded: entry too large: 1072 bytes",
+		},
+		{
+			name:     "hostile entry size",
+			r:        struct{ io.Reader }{bytes.NewReader([]byte("\x00\x00\x01\x00\x01\x00\x10\x10\x00\x00\x01\x00\x20\x00\xFF\xFF\xFF\xFF\x16\x00\x00\x00"))},
+			o:        &DecodeOptions{MaxEntrySize: 1 << 20},
+			expected: "ico: icon 0 at offset 6: limit exceeded: entry too large: 4294967295 bytes",
+		},
+		{
+			name:     "total size",
+			r:        bytes.NewReader(b),
+			o:        &DecodeOptions{MaxTotalSize: 4096},
+			expected: "ico: limit exceeded: entries too large: 4656 bytes",
+		},
+		{
+			name:     "dimensions",
+			r:        struct{ io.Reader }{bytes.NewReader(b)},
+			o:        &DecodeOptions{MaxWidth: 128, MaxHeight: 128},
+			expected: "ico: icon 11 at offset 31422: limit exceeded: image too large: 256x256",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewDecoder(test.r, test.o)
+			if !errors.Is(err, ErrLimit) || err.Error() != test.expected {
+				t.Fatalf("NewDecoder() = _, %v; want %s", err, test.expected)
+			}
+		})
+	}
+	d, err := NewDecoder(bytes.NewReader(b), &DecodeOptions{
+		Keep:      func(e Entry) bool { return e.Width <= 64 },
+		MaxWidth:  64,
+		MaxHeight: 64,
+	})
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	if actual, expected := len(d.Entries()), len(testutil.Icon.Entries)-1; actual != expected {
+		t.Fatalf("len(Decoder.Entries()) = %d; want %d", actual, expected)
+	}
+}
+
+func TestDecoderMaskFirst(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0x11, 0x22, 0x33, 0x80})
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{Format: AlwaysBMP, BPP: 32})
+	if err := e.Add(src, nil); err != nil {
+		t.Fatalf("Encoder.Add() = %v; want nil", err)
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	for _, maskFirst := range []bool{false, true} {
+		d, err := NewDecoder(bytes.NewReader(buf.Bytes()), &DecodeOptions{MaskFirst: maskFirst})
+		if err != nil {
+			t.Fatalf("NewDecoder() = _, %v; want nil", err)
+		}
+		m, err := d.Decode(0)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		expected := []color.NRGBA{{0x11, 0x22, 0x33, 0x80}, {}}
+		if maskFirst {
+			expected[0].A = 0xFF
+		}
+		for x, c := range expected {
+			if actual := color.NRGBAModel.Convert(m.At(x, 0)); actual != c {
+				t.Errorf("MaskFirst = %t: At(%d, 0) = %v; want %v", maskFirst, x, actual, c)
+			}
+		}
+	}
+}
+
+func TestEntryError(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	// Break the checksum of the 256x256 PNG IHDR chunk.
+	b[31422+29]++
+	_, err := DecodeAll(bytes.NewReader(b))
+	if expected := "ico: icon 11 at offset 31422: png: invalid format: invalid checksum"; err == nil || err.Error() != expected {
+		t.Fatalf("DecodeAll() = _, %v; want %s", err, expected)
+	}
+	var entryErr *EntryError
+	if !errors.As(err, &entryErr) {
+		t.Fatalf("errors.As(%v, *EntryError) = false; want true", err)
+	}
+	if entryErr.Entry != 11 || entryErr.Offset != 31422 {
+		t.Errorf("EntryError = %d, %d; want 11, 31422", entryErr.Entry, entryErr.Offset)
+	}
+	var pngErr png.FormatError
+	if !errors.As(err, &pngErr) {
+		t.Errorf("errors.As(%v, png.FormatError) = false; want true", err)
+	}
+	for _, target := range []error{ErrFormat, cur.ErrFormat} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false; want true", err, target)
+		}
+	}
+	if errors.Is(err, ErrUnsupported) {
+		t.Errorf("errors.Is(%v, %v) = true; want false", err, ErrUnsupported)
+	}
+	_, err = Decode(bytes.NewReader(make([]byte, 10)))
+	if !errors.Is(err, cur.ErrFormat) {
+		t.Errorf("errors.Is(%v, %v) = false; want true", err, cur.ErrFormat)
+	}
+}
+
+func TestDecoderInvalidIndex(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testutil.Icon.MustRead()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	n := len(d.Entries())
+	if _, err := d.Decode(n); err != ErrIndex {
+		t.Errorf("Decoder.Decode() = _, %v; want %v", err, ErrIndex)
+	}
+	if _, _, err := d.DecodeMask(n); err != ErrIndex {
+		t.Errorf("Decoder.DecodeMask() = _, _, %v; want %v", err, ErrIndex)
+	}
+	if _, err := d.DecodeConfig(n); err != ErrIndex {
+		t.Errorf("Decoder.DecodeConfig() = _, %v; want %v", err, ErrIndex)
+	}
+	_, err = d.ReadRaw(-1)
+	if err != ErrIndex {
+		t.Errorf("Decoder.ReadRaw() = _, %v; want %v", err, ErrIndex)
+	}
+	if errors.Is(err, ErrFormat) {
+		t.Errorf("errors.Is(%v, ErrFormat) = true; want false", err)
+	}
+}
+
+func TestDecodeSize(t *testing.T) {
+	tests := []struct {
+		width, height, bpp int
+		scale              float64
+		expected           int
+	}{
+		{16, 16, 32, 1, 14},
+		{16, 16, 8, 0, 7},
+		{16, 16, 32, 1.25, 13},
+		{16, 16, 24, 1.5, 9},
+		{32, 32, 32, 2, 12},
+	}
+	b := testutil.Icon.MustRead()
+	for _, test := range tests {
+		m, err := DecodeSize(bytes.NewReader(b), test.width, test.height, test.bpp, test.scale)
+		if err != nil {
+			t.Fatalf("DecodeSize() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, testutil.Icon.Entries[test.expected].MustDecode(), m)
+	}
+}
+
+func TestDecoderReadRaw(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	d, err := NewDecoder(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, e := range d.Entries() {
+		raw, err := d.ReadRaw(i)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		if !bytes.Equal(raw, b[e.Offset:e.Offset+e.Size]) {
+			t.Errorf("Decoder.ReadRaw(%d) returned different data", i)
+		}
+	}
+}
+
+func TestNewDecoderAt(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	d, err := NewDecoderAt(bytes.NewReader(b), int64(len(b)), nil)
+	if err != nil {
+		t.Fatalf("NewDecoderAt() = _, %v; want nil", err)
+	}
+	mm := make([]image.Image, len(d.Entries()))
+	errs := make([]error, len(mm))
+	var wg sync.WaitGroup
+	for i := range mm {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mm[i], errs[i] = d.Decode(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+	}
+	testutil.CompareIconDir(t, testutil.Icon, nil, mm)
+}
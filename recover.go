@@ -0,0 +1,33 @@
+package ico
+
+import (
+	"image"
+	"io"
+
+	"github.com/sergeymakinen/go-ico/internal/icondir"
+)
+
+// Recover reads a damaged ICO image from r and decodes as many icons as possible.
+// It tolerates a wrong icon count and data sizes running past the end of the file,
+// looks for the icon data around offsets that are a few bytes off and scans
+// the file for BMP and PNG icons not listed in the directory. The icons found
+// in the directory come first, followed by the unlisted ones.
+// The problems found are returned as issues.
+// The MaxWidth and MaxHeight limits of o are honored by skipping larger icons,
+// the other fields of o are ignored. If o is nil, no limits are applied.
+// It returns an error if r can't be read or no icons are recovered.
+func Recover(r io.Reader, o *DecodeOptions) ([]image.Image, []Issue, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var limits icondir.Limits
+	if o != nil {
+		limits.Width, limits.Height = o.MaxWidth, o.MaxHeight
+	}
+	_, mm, issues := icondir.Recover(b, true, limits)
+	if len(mm) == 0 {
+		return nil, newIssues(issues), FormatError("no icons recovered")
+	}
+	return mm, newIssues(issues), nil
+}
@@ -0,0 +1,103 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(b []byte) []byte
+		issues []string
+	}{
+		{
+			name:   "intact",
+			damage: func(b []byte) []byte { return b },
+		},
+		{
+			name: "too many entries",
+			damage: func(b []byte) []byte {
+				binary.LittleEndian.PutUint16(b[4:], 16)
+				return b
+			},
+			issues: []string{"15@65537: error: no image data found at 65537"},
+		},
+		{
+			name: "too few entries",
+			damage: func(b []byte) []byte {
+				binary.LittleEndian.PutUint16(b[4:], 14)
+				return b
+			},
+			issues: []string{"-1@97358: warning: recovered an image not listed in the directory"},
+		},
+		{
+			name: "wrong offset",
+			damage: func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[54+12:], binary.LittleEndian.Uint32(b[54+12:])-3)
+				return b
+			},
+			issues: []string{"3@4158: warning: image data found at 4158 instead of 4155"},
+		},
+		{
+			name: "wrong size",
+			damage: func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[54+8:], 100)
+				binary.LittleEndian.PutUint32(b[6+8:], 1<<20)
+				return b
+			},
+			issues: []string{
+				"0@246: warning: data of 1048576 bytes runs past the end of the file",
+				"3@4158: warning: wrong data size: 100",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mm, issues, err := Recover(bytes.NewReader(test.damage(testutil.Icon.MustRead())), nil)
+			if err != nil {
+				t.Fatalf("Recover() = _, _, %v; want nil", err)
+			}
+			var actual []string
+			for _, i := range issues {
+				actual = append(actual, fmt.Sprintf("%d@%d: %s", i.Entry, i.Offset, i))
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(test.issues) {
+				t.Errorf("Recover() = _, %q, nil; want %q", actual, test.issues)
+			}
+			testutil.CompareIconDir(t, testutil.Icon, nil, mm)
+		})
+	}
+}
+
+func TestRecoverPNG(t *testing.T) {
+	e := testutil.Icon.Entries[11]
+	b, err := os.ReadFile(filepath.Join("internal", "testutil", "testdata", e.String()))
+	if err != nil {
+		t.Fatalf("os.ReadFile() = _, %v; want nil", err)
+	}
+	mm, issues, err := Recover(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("Recover() = _, _, %v; want nil", err)
+	}
+	if actual, expected := len(mm), 1; actual != expected {
+		t.Fatalf("len([]image.Image) = %d; want %d", actual, expected)
+	}
+	testutil.Compare(t, e.MustDecode(), mm[0])
+	if actual, expected := fmt.Sprint(issues), "[error: not an ICO file warning: recovered an image not listed in the directory]"; actual != expected {
+		t.Errorf("Recover() = _, %s, nil; want %s", actual, expected)
+	}
+}
+
+func TestRecoverShouldFail(t *testing.T) {
+	_, _, err := Recover(bytes.NewReader(make([]byte, 100)), nil)
+	if expected := "ico: invalid format: no icons recovered"; err == nil || err.Error() != expected {
+		t.Fatalf("Recover() = _, _, %v; want %s", err, expected)
+	}
+}
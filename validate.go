@@ -0,0 +1,73 @@
+package ico
+
+import (
+	"io"
+
+	"github.com/sergeymakinen/go-ico/internal/icondir"
+)
+
+// Severity is the severity of an Issue.
+type Severity int
+
+const (
+	// SeverityWarning means the file violates the ICO format, but can still be decoded.
+	SeverityWarning Severity = iota + 1
+
+	// SeverityError means the file or some of the icons can't be decoded.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Issue describes a problem found in an ICO file.
+type Issue struct {
+	Severity Severity
+
+	// Entry is the index of the icon in the directory or -1
+	// if the problem isn't related to a single icon.
+	Entry int
+
+	// Offset is the position in the ICO file the problem is found at.
+	Offset int64
+
+	Message string
+}
+
+func (i Issue) String() string {
+	return i.Severity.String() + ": " + i.Message
+}
+
+// Validate reads an ICO image from r and reports its problems, such as
+// the directory disagreeing with the stored icons, overlapping or
+// out-of-bounds icon data, duplicate icons or PNG icons that aren't 32-bit.
+// Unlike Decode, it doesn't stop at the first problem.
+// It returns nil if no problems are found.
+func Validate(r io.Reader) []Issue {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return []Issue{{Severity: SeverityError, Entry: -1, Offset: int64(len(b)), Message: err.Error()}}
+	}
+	return newIssues(icondir.Validate(b, true))
+}
+
+func newIssues(ii []icondir.Issue) []Issue {
+	var issues []Issue
+	for _, i := range ii {
+		issues = append(issues, Issue{
+			Severity: Severity(i.Severity),
+			Entry:    i.Entry,
+			Offset:   i.Offset,
+			Message:  i.Message,
+		})
+	}
+	return issues
+}
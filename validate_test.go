@@ -0,0 +1,52 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/sergeymakinen/go-ico/internal/testutil"
+)
+
+func TestValidate(t *testing.T) {
+	if issues := Validate(bytes.NewReader(testutil.Icon.MustRead())); issues != nil {
+		t.Errorf("Validate() = %v; want nil", issues)
+	}
+	var mm []image.Image
+	for _, e := range testutil.Icon.Entries {
+		mm = append(mm, e.MustDecode())
+	}
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, mm[len(mm)-4:]); err != nil {
+		t.Fatalf("EncodeAll() = %v; want nil", err)
+	}
+	if issues := Validate(&buf); issues != nil {
+		t.Errorf("Validate() = %v; want nil", issues)
+	}
+}
+
+func TestValidateIssues(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	b[6] = 32
+	b[22+4] = 2
+	b[38+12], b[38+13] = 10, 0
+	copy(b[70:86], b[54:70])
+	binary.LittleEndian.PutUint32(b[86+8:], 1<<20)
+	var actual []string
+	for _, i := range Validate(bytes.NewReader(b)) {
+		actual = append(actual, fmt.Sprintf("%d@%d: %s", i.Entry, i.Offset, i))
+	}
+	expected := []string{
+		"0@6: warning: directory size 32x64 doesn't match image size 64x64",
+		"1@26: warning: invalid color planes: 2",
+		"2@50: error: data at 10 points into the directory",
+		"4@70: warning: duplicates entry 3: 32x32, 4 bits per pixel",
+		"4@4158: error: data overlaps entry 3",
+		"5@94: error: data at 5198 of 1048576 bytes is out of bounds",
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Validate() = %q; want %q", actual, expected)
+	}
+}
@@ -2,27 +2,284 @@ package ico
 
 import (
 	"image"
+	"image/color"
 	"io"
 
 	"github.com/sergeymakinen/go-ico/internal/icondir"
+	"github.com/sergeymakinen/go-ico/internal/quantize"
 )
 
+// Dither is a dithering method used when an image is quantized
+// to a 1, 2, 4 or 8 bit-per-pixel palette.
+type Dither int
+
+const (
+	// NoDither maps every pixel to the nearest palette color.
+	NoDither Dither = iota
+
+	// FloydSteinberg is the Floyd-Steinberg error diffusion.
+	FloydSteinberg
+
+	// Atkinson is the Atkinson error diffusion, which spreads only 3/4 of the error
+	// and keeps more contrast.
+	Atkinson
+
+	// Bayer is the ordered dithering with the 8x8 Bayer matrix.
+	Bayer
+)
+
+func (d Dither) dither() quantize.Dither {
+	switch d {
+	case FloydSteinberg:
+		return quantize.FloydSteinberg
+	case Atkinson:
+		return quantize.Atkinson
+	case Bayer:
+		return quantize.Bayer
+	default:
+		return quantize.None
+	}
+}
+
+// Format is a policy of choosing between the PNG and BMP formats for icons.
+// PNG icons are always 32 bits per pixel, so icons with other BPP
+// are stored as BMP images regardless of the policy.
+type Format int
+
+const (
+	// PNGAt256 stores 256x256 icons which are not paletted or grayscale
+	// as PNG images and the others as BMP images. It is the default.
+	PNGAt256 Format = iota + 1
+
+	// AlwaysBMP stores all icons as BMP images, which is compatible with Windows XP
+	// and earlier.
+	AlwaysBMP
+
+	// AlwaysPNG stores all icons as PNG images, which produces the smallest files
+	// supported by Windows Vista and later.
+	AlwaysPNG
+
+	// Smallest encodes an icon both as a PNG and BMP image and keeps the smaller one.
+	Smallest
+)
+
+func (f Format) format() icondir.Format {
+	switch f {
+	case AlwaysBMP:
+		return icondir.FormatBMP
+	case AlwaysPNG:
+		return icondir.FormatPNG
+	case Smallest:
+		return icondir.FormatSmallest
+	default:
+		return icondir.FormatPNGAt256
+	}
+}
+
+// BMPHeader is a version of the info header of icons stored as BMP images.
+type BMPHeader int
+
+const (
+	// InfoHeader is the 40-byte BITMAPINFOHEADER supported by every reader.
+	// It is the default.
+	InfoHeader BMPHeader = iota + 1
+
+	// V4Header is the 108-byte BITMAPV4HEADER. 32 bit-per-pixel icons are stored
+	// with explicit color and alpha masks, and the color space is sRGB.
+	V4Header
+
+	// V5Header is the 124-byte BITMAPV5HEADER, which extends V4Header
+	// with the rendering intent.
+	V5Header
+)
+
+func (h BMPHeader) infoHeaderLen() int {
+	switch h {
+	case V4Header:
+		return 108
+	case V5Header:
+		return 124
+	default:
+		return 40
+	}
+}
+
+// EncodeOptions are the encoding parameters.
+type EncodeOptions struct {
+	// BPP is the number of bits per pixel of icons stored as BMP images:
+	// 1, 2, 4, 8, 24 or 32. Images with more colors than a 1, 2, 4 or 8 bit-per-pixel
+	// palette can hold are quantized, which is lossy: for example, BPP 2 reduces
+	// any image to 4 colors. Fully transparent pixels are stored
+	// in the AND mask and don't take palette colors.
+	// If BPP is 0, it is chosen from the image type. 2 bit-per-pixel icons,
+	// which are only read by Windows CE and some old tools, are chosen only
+	// if TwoBPP is set.
+	BPP int
+
+	// TwoBPP lets BPP 0 choose 2 bits per pixel for paletted images
+	// with 3 or 4 opaque colors instead of 4 bits per pixel.
+	TwoBPP bool
+
+	// Dither is the dithering method used when an image is quantized.
+	// Fully transparent pixels are excluded from error diffusion.
+	Dither Dither
+
+	// Format is the policy of choosing between the PNG and BMP formats.
+	// If Format is 0, PNGAt256 is used.
+	Format Format
+
+	// Large allows icons larger than 256x256, which are always stored as PNG images.
+	// Such icons are supported by Windows 10 and later.
+	Large bool
+
+	// BMPHeader is the info header version of icons stored as BMP images.
+	// If BMPHeader is 0, InfoHeader is used.
+	BMPHeader BMPHeader
+
+	// AlphaThreshold, if positive, makes the pixels of icons stored as BMP images
+	// with up to 24 bits per pixel and the 8-bit alpha less than AlphaThreshold
+	// fully transparent, so they're stored in the AND mask. For example, 128 masks
+	// the pixels that are more transparent than opaque. 32 bit-per-pixel icons keep
+	// their alpha channel untouched. If AlphaThreshold is 0, only fully transparent
+	// pixels are masked.
+	AlphaThreshold uint8
+
+	// ColorKey, if not nil, is the color made fully transparent,
+	// such as magenta (0xFF00FF) in artwork without alpha.
+	// Only the red, green and blue components are compared.
+	ColorKey color.Color
+
+	// KeepPalette stores *image.Paletted icons with their palette and indices
+	// unchanged instead of leaving out transparent colors and remapping the pixels,
+	// so the index layout survives a round trip through Decoder.Decode.
+	// Transparent pixels are stored in the AND mask only, and fully transparent colors
+	// at the end of the palette, such as the one added by Decode, are left out.
+	// A transparent color used by the transparent pixels only is decoded
+	// at its original index.
+	// BPP, if set, must fit the palette. It applies to icons stored as BMP images
+	// with up to 8 bits per pixel.
+	KeepPalette bool
+}
+
+// merge returns o with the zero fields set to the ones of defaults,
+// so a zero field can't override a non-zero default.
+func (o *EncodeOptions) merge(defaults *EncodeOptions) EncodeOptions {
+	var merged EncodeOptions
+	if defaults != nil {
+		merged = *defaults
+	}
+	if o == nil {
+		return merged
+	}
+	if o.BPP != 0 {
+		merged.BPP = o.BPP
+	}
+	if o.Dither != NoDither {
+		merged.Dither = o.Dither
+	}
+	if o.Format != 0 {
+		merged.Format = o.Format
+	}
+	if o.Large {
+		merged.Large = true
+	}
+	if o.BMPHeader != 0 {
+		merged.BMPHeader = o.BMPHeader
+	}
+	if o.AlphaThreshold != 0 {
+		merged.AlphaThreshold = o.AlphaThreshold
+	}
+	if o.ColorKey != nil {
+		merged.ColorKey = o.ColorKey
+	}
+	if o.KeepPalette {
+		merged.KeepPalette = true
+	}
+	if o.TwoBPP {
+		merged.TwoBPP = true
+	}
+	return merged
+}
+
+func (o EncodeOptions) options() icondir.Options {
+	return icondir.Options{
+		BPP:    o.BPP,
+		Dither: o.Dither.dither(),
+		Format: o.Format.format(),
+		Large:  o.Large,
+
+		InfoHeaderLen:  o.BMPHeader.infoHeaderLen(),
+		AlphaThreshold: o.AlphaThreshold,
+		ColorKey:       o.ColorKey,
+		KeepPalette:    o.KeepPalette,
+		TwoBPP:         o.TwoBPP,
+	}
+}
+
+// Encoder writes icons to an ICO file.
+type Encoder struct {
+	e *icondir.Encoder
+	o *EncodeOptions
+}
+
+// NewEncoder returns an Encoder writing to w. The options o, which may be nil,
+// apply to every added icon unless overridden by the options passed to Add.
+func NewEncoder(w io.Writer, o *EncodeOptions) *Encoder {
+	return &Encoder{
+		e: icondir.NewEncoder(w, true),
+		o: o,
+	}
+}
+
+// Add adds the icon m. The non-zero fields of o, which may be nil,
+// override the options of the Encoder for this icon. As zero fields are
+// ignored, o can only add settings: it can't reset BPP, AlphaThreshold
+// or ColorKey, select NoDither or turn off Large, KeepPalette or TwoBPP
+// set in the options of the Encoder. Such settings are meant to be set
+// for individual icons rather than for the Encoder.
+func (e *Encoder) Add(m image.Image, o *EncodeOptions) error {
+	return convertErr(e.e.AddOptions(m, 0, 0, o.merge(e.o).options()))
+}
+
+// AddMask adds the icon m with the explicit AND mask, which must have the same size.
+// The mask bits are set where the mask pixels are lighter than 50% gray,
+// making the screen transparent, or inverted where m is white, such as
+// returned by Decoder.DecodeMask. The icon is always stored as a BMP image
+// unless the mask is nil, such as for icons stored as PNG images,
+// in which case AddMask is the same as Add.
+func (e *Encoder) AddMask(m, mask image.Image, o *EncodeOptions) error {
+	options := o.merge(e.o).options()
+	options.Mask = mask
+	return convertErr(e.e.AddOptions(m, 0, 0, options))
+}
+
+// AddRaw adds the icon stored as the BMP or PNG data b, such as returned by
+// Decoder.ReadRaw, without reencoding it.
+func (e *Encoder) AddRaw(b []byte) error {
+	return convertErr(e.e.AddRaw(b, 0, 0))
+}
+
+// Encode writes the added icons to w in ICO format.
+func (e *Encoder) Encode() error {
+	return convertErr(e.e.Encode())
+}
+
 // EncodeAll writes the icons in mm to w in ICO format.
 func EncodeAll(w io.Writer, mm []image.Image) error {
-	e := icondir.NewEncoder(w, true)
+	e := NewEncoder(w, nil)
 	for _, m := range mm {
-		if err := e.Add(m, 0, 0); err != nil {
-			return convertErr(err)
+		if err := e.Add(m, nil); err != nil {
+			return err
 		}
 	}
-	return convertErr(e.Encode())
+	return e.Encode()
 }
 
 // Encode writes the icon m to w in ICO format.
 func Encode(w io.Writer, m image.Image) error {
-	e := icondir.NewEncoder(w, true)
-	if err := e.Add(m, 0, 0); err != nil {
-		return convertErr(err)
+	e := NewEncoder(w, nil)
+	if err := e.Add(m, nil); err != nil {
+		return err
 	}
-	return convertErr(e.Encode())
+	return e.Encode()
 }
@@ -2,7 +2,11 @@ package ico
 
 import (
 	"bytes"
+	"encoding/binary"
 	"image"
+	"image/color"
+	"io"
+	"reflect"
 	"testing"
 
 	"github.com/sergeymakinen/go-ico/internal/testutil"
@@ -57,3 +61,397 @@ func TestEncodeShouldFail(t *testing.T) {
 		t.Fatalf("Encode() = %v; want %s", err, expected)
 	}
 }
+
+func TestEncoder(t *testing.T) {
+	src := testutil.Icon.Entries[12].MustDecode()
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{BPP: 8})
+	for _, o := range []*EncodeOptions{nil, {BPP: 4}, {BPP: 32}} {
+		if err := e.Add(src, o); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, expected := range []int{8, 4, 32} {
+		if actual := d.Entries()[i].BPP; actual != expected {
+			t.Errorf("Decoder.Entries()[%d].BPP = %d; want %d", i, actual, expected)
+		}
+	}
+	m, err := d.Decode(2)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, src, m)
+}
+
+func TestEncoderShouldFail(t *testing.T) {
+	e := NewEncoder(&bytes.Buffer{}, nil)
+	err := e.Add(testutil.Icon.Entries[12].MustDecode(), &EncodeOptions{BPP: 3})
+	if expected := "ico: unsupported feature: bit depth 3"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.Add() = %v; want %s", err, expected)
+	}
+}
+
+func TestEncoder2BPP(t *testing.T) {
+	p := color.Palette{color.Transparent, color.Black, color.White, color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}}
+	src := image.NewPaletted(image.Rect(0, 0, 7, 5), p)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % len(p))
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{BPP: 2})
+	if err := e.Add(src, nil); err != nil {
+		t.Fatalf("Encoder.Add() = %v; want nil", err)
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	if entry := d.Entries()[0]; entry.BPP != 2 || entry.Colors != 4 {
+		t.Errorf("Entry = %d BPP, %d colors; want 2 BPP, 4 colors", entry.BPP, entry.Colors)
+	}
+	m, err := d.Decode(0)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	testutil.Compare(t, src, m)
+}
+
+func TestEncoderTwoBPP(t *testing.T) {
+	p := color.Palette{color.Black, color.White, color.RGBA{0xFF, 0, 0, 0xFF}, color.Transparent}
+	src := image.NewPaletted(image.Rect(0, 0, 7, 5), p)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % len(p))
+	}
+	for _, twoBPP := range []bool{false, true} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, &EncodeOptions{TwoBPP: twoBPP})
+		if err := e.Add(src, nil); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+		if err := e.Encode(); err != nil {
+			t.Fatalf("Encoder.Encode() = %v; want nil", err)
+		}
+		d, err := NewDecoder(&buf, nil)
+		if err != nil {
+			t.Fatalf("NewDecoder() = _, %v; want nil", err)
+		}
+		expected := 4
+		if twoBPP {
+			expected = 2
+		}
+		if actual := d.Entries()[0].BPP; actual != expected {
+			t.Errorf("Entry.BPP = %d; want %d", actual, expected)
+		}
+		m, err := d.Decode(0)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, src, m)
+	}
+}
+
+func TestEncoderDither(t *testing.T) {
+	src := testutil.Icon.Entries[13].MustDecode()
+	var undithered image.Image
+	for _, d := range []Dither{NoDither, FloydSteinberg, Atkinson, Bayer} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, &EncodeOptions{BPP: 4, Dither: d})
+		if err := e.Add(src, nil); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+		if err := e.Encode(); err != nil {
+			t.Fatalf("Encoder.Encode() = %v; want nil", err)
+		}
+		m, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decode() = _, %v; want nil", err)
+		}
+		if !m.Bounds().Eq(src.Bounds()) {
+			t.Fatalf("Bounds() = %s; want %s", m.Bounds(), src.Bounds())
+		}
+		transparent, same := 0, true
+		for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+			for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
+				if _, _, _, a := src.At(x, y).RGBA(); a == 0 {
+					transparent++
+					if _, _, _, a := m.At(x, y).RGBA(); a != 0 {
+						t.Errorf("Dither %d: At(%d, %d).A = %d; want 0", d, x, y, a)
+					}
+				}
+				if undithered != nil && color.NRGBAModel.Convert(m.At(x, y)) != color.NRGBAModel.Convert(undithered.At(x, y)) {
+					same = false
+				}
+			}
+		}
+		if transparent == 0 {
+			t.Fatal("no transparent pixels in the source image")
+		}
+		if d == NoDither {
+			undithered = m
+		} else if same {
+			t.Errorf("Dither %d: the image is the same as with NoDither", d)
+		}
+	}
+}
+
+func TestEncoderFormat(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{Format: AlwaysPNG})
+	for _, o := range []*EncodeOptions{nil, {Format: PNGAt256}, {Format: AlwaysBMP}} {
+		if err := e.Add(testutil.Icon.Entries[14].MustDecode(), o); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, expected := range []bool{true, false, false} {
+		if actual := d.Entries()[i].PNG; actual != expected {
+			t.Errorf("Decoder.Entries()[%d].PNG = %t; want %t", i, actual, expected)
+		}
+		m, err := d.Decode(i)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, testutil.Icon.Entries[14].MustDecode(), m)
+	}
+}
+
+func TestEncoderBMPHeader(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{Format: AlwaysBMP, BMPHeader: V5Header})
+	for _, o := range []*EncodeOptions{nil, {BMPHeader: V4Header}, {BMPHeader: InfoHeader}} {
+		if err := e.Add(testutil.Icon.Entries[14].MustDecode(), o); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, expected := range []uint32{124, 108, 40} {
+		b, err := d.ReadRaw(i)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		if actual := binary.LittleEndian.Uint32(b); actual != expected {
+			t.Errorf("info header length = %d; want %d", actual, expected)
+		}
+		m, err := d.Decode(i)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, testutil.Icon.Entries[14].MustDecode(), m)
+	}
+}
+
+func TestEncoderAddMask(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testutil.Icon.MustRead()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, nil)
+	for i := range testutil.Icon.Entries {
+		m, mask, err := d.DecodeMask(i)
+		if err != nil {
+			t.Fatalf("Decoder.DecodeMask() = _, _, %v; want nil", err)
+		}
+		if err := e.AddMask(m, mask, nil); err != nil {
+			t.Fatalf("Encoder.AddMask() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d2, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, e := range testutil.Icon.Entries {
+		m, err := d2.Decode(i)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		testutil.Compare(t, e.MustDecode(), m)
+	}
+	if entry := d2.Entries()[11]; !entry.PNG {
+		t.Errorf("Decoder.Entries()[11].PNG = false; want true")
+	}
+	if _, mask, err := d.DecodeMask(11); err != nil || mask != nil {
+		t.Errorf("Decoder.DecodeMask() = _, %v, %v; want nil, nil", mask, err)
+	}
+	err = e.AddMask(image.NewGray(image.Rect(0, 0, 2, 2)), image.NewGray(image.Rect(0, 0, 2, 1)), nil)
+	if expected := "ico: invalid format: mismatched mask size: 2x1"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.AddMask() = %v; want %s", err, expected)
+	}
+}
+
+func TestEncoderAlphaThreshold(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x, a := range []uint8{0x40, 0x80, 0xFF} {
+		src.SetNRGBA(x, 0, color.NRGBA{0xFF, 0, 0, a})
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{BPP: 24})
+	for _, o := range []*EncodeOptions{nil, {AlphaThreshold: 128}, {BPP: 32, AlphaThreshold: 128}} {
+		if err := e.Add(src, o); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	d, err := NewDecoder(&buf, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	for i, expected := range [][]uint32{{0xFFFF, 0xFFFF, 0xFFFF}, {0, 0xFFFF, 0xFFFF}, {0x4040, 0x8080, 0xFFFF}} {
+		m, err := d.Decode(i)
+		if err != nil {
+			t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+		}
+		for x, a := range expected {
+			if _, _, _, actual := m.At(x, 0).RGBA(); actual != a {
+				t.Errorf("Decode(%d).At(%d, 0).A = %d; want %d", i, x, actual, a)
+			}
+		}
+	}
+	// The 32 bit-per-pixel icon keeps the partial alpha out of the mask.
+	_, mask, err := d.DecodeMask(2)
+	if err != nil {
+		t.Fatalf("Decoder.DecodeMask() = _, _, %v; want nil", err)
+	}
+	for x, expected := range []uint8{0, 0, 0} {
+		if actual := mask.ColorIndexAt(x, 0); actual != expected {
+			t.Errorf("DecodeMask(2).ColorIndexAt(%d, 0) = %d; want %d", x, actual, expected)
+		}
+	}
+}
+
+func TestEncoderColorKey(t *testing.T) {
+	magenta := color.RGBA{0xFF, 0, 0xFF, 0xFF}
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgba.SetRGBA(0, 0, magenta)
+	rgba.SetRGBA(1, 0, color.RGBA{0xFF, 0, 0, 0xFF})
+	paletted := image.NewPaletted(rgba.Rect, color.Palette{magenta, color.RGBA{0xFF, 0, 0, 0xFF}})
+	paletted.SetColorIndex(1, 0, 1)
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{ColorKey: color.RGBA{0xFF, 0, 0xFF, 0xFF}})
+	for _, m := range []image.Image{rgba, paletted} {
+		for _, o := range []*EncodeOptions{{Format: AlwaysBMP}, {Format: AlwaysPNG}} {
+			if err := e.Add(m, o); err != nil {
+				t.Fatalf("Encoder.Add() = %v; want nil", err)
+			}
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	mm, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll() = _, %v; want nil", err)
+	}
+	for i, m := range mm {
+		if _, _, _, a := m.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("DecodeAll()[%d].At(0, 0).A = %d; want 0", i, a)
+		}
+		if c := color.NRGBAModel.Convert(m.At(1, 0)); c != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+			t.Errorf("DecodeAll()[%d].At(1, 0) = %v; want %v", i, c, color.NRGBA{0xFF, 0, 0, 0xFF})
+		}
+	}
+}
+
+func TestEncoderKeepPalette(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testutil.Icon.MustRead()), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	src1, err := d.Decode(2)
+	if err != nil {
+		t.Fatalf("Decoder.Decode() = _, %v; want nil", err)
+	}
+	src2 := image.NewPaletted(image.Rect(0, 0, 3, 1), color.Palette{color.RGBA{0xFF, 0, 0, 0xFF}, color.Transparent, color.RGBA{0, 0, 0xFF, 0xFF}})
+	src2.Pix = []uint8{2, 1, 0}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, &EncodeOptions{KeepPalette: true})
+	for _, m := range []image.Image{src1, src2} {
+		if err := e.Add(m, nil); err != nil {
+			t.Fatalf("Encoder.Add() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	mm, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll() = _, %v; want nil", err)
+	}
+	m1, ok := mm[0].(*image.Paletted)
+	if !ok {
+		t.Fatalf("DecodeAll()[0] = %T; want *image.Paletted", mm[0])
+	}
+	if p := src1.(*image.Paletted); !reflect.DeepEqual(m1.Palette, p.Palette) || !bytes.Equal(m1.Pix, p.Pix) {
+		t.Errorf("DecodeAll()[0] = %v, %v; want %v, %v", m1.Palette, m1.Pix, p.Palette, p.Pix)
+	}
+	m2 := mm[1].(*image.Paletted)
+	if !reflect.DeepEqual(m2.Palette, src2.Palette) || !bytes.Equal(m2.Pix, src2.Pix) {
+		t.Errorf("DecodeAll()[1] = %v, %v; want %v, %v", m2.Palette, m2.Pix, src2.Palette, src2.Pix)
+	}
+	testutil.Compare(t, src2, m2)
+	err = e.Add(src2, &EncodeOptions{BPP: 1})
+	if expected := "ico: unsupported feature: palette of 3 colors at bit depth 1"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.Add() = %v; want %s", err, expected)
+	}
+}
+
+func TestEncoderAddRaw(t *testing.T) {
+	b := testutil.Icon.MustRead()
+	d, err := NewDecoder(struct{ io.Reader }{bytes.NewReader(b)}, nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() = _, %v; want nil", err)
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, nil)
+	for i := range d.Entries() {
+		raw, err := d.ReadRaw(i)
+		if err != nil {
+			t.Fatalf("Decoder.ReadRaw() = _, %v; want nil", err)
+		}
+		if err := e.AddRaw(raw); err != nil {
+			t.Fatalf("Encoder.AddRaw() = %v; want nil", err)
+		}
+	}
+	if err := e.Encode(); err != nil {
+		t.Fatalf("Encoder.Encode() = %v; want nil", err)
+	}
+	// The test file has no gaps between entries, so it must be reproduced exactly.
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("Encoder.Encode() wrote different data")
+	}
+}
+
+func TestEncoderAddRawShouldFail(t *testing.T) {
+	e := NewEncoder(&bytes.Buffer{}, nil)
+	err := e.AddRaw(make([]byte, 10))
+	if expected := "ico: invalid format: truncated image data"; err == nil || err.Error() != expected {
+		t.Fatalf("Encoder.AddRaw() = %v; want %s", err, expected)
+	}
+}